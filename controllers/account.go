@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+	"trip-planner/db"
+	"trip-planner/mailer"
+	"trip-planner/models"
+	"trip-planner/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Lifetimes of the tokens sent by email
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+)
+
+// RequestPasswordReset emails a reset link. It always answers Accepted so callers can't
+// probe which addresses are registered.
+func RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		http.Error(w, "Email must be provided", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	err := db.UserCollection.FindOne(context.Background(),
+		bson.M{"email": utils.NormalizeEmail(body.Email)},
+		options.FindOne().SetCollation(db.CaseInsensitive),
+	).Decode(&user)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		// Fall through to the same response as a real account
+	case err != nil:
+		log.Printf("Failed to look up user for password reset: %v", err)
+	case user.Disabled:
+		// Disabled accounts can't reset their way back in
+	default:
+		token, err := utils.CreateUserToken(context.Background(), user.ID, models.TokenPurposePasswordReset, passwordResetTTL)
+		if err != nil {
+			log.Printf("Failed to create password reset token for user %s: %v", user.ID.Hex(), err)
+			break
+		}
+		if err := mailer.Default.Send(context.Background(), passwordResetMessage(user.Email, token)); err != nil {
+			log.Printf("Failed to send password reset email to user %s: %v", user.ID.Hex(), err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("If the account exists, a reset email has been sent"))
+}
+
+// ResetPassword sets a new password using a reset token and signs the user out everywhere
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		http.Error(w, "Token must be provided", http.StatusBadRequest)
+		return
+	}
+	if err := utils.ValidatePassword(body.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ConsumeUserToken(context.Background(), body.Token, models.TokenPurposePasswordReset)
+	if err != nil {
+		if err == utils.ErrInvalidUserToken {
+			http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	hash, err := utils.HashPassword(body.NewPassword)
+	if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	// Receiving the reset email also proves the address belongs to the user, which is
+	// enough to grant a listed admin their role as VerifyEmail would
+	var user models.User
+	err = db.UserCollection.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"password": hash, "email_verified": true}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == nil {
+		err = promoteListedAdmin(r, &user)
+	}
+	if err != nil {
+		http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := utils.RevokeAllForUser(context.Background(), userID, "password reset"); err != nil {
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// VerifyEmail marks the user's email as verified using a verification token
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		http.Error(w, "Token must be provided", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := utils.ConsumeUserToken(context.Background(), body.Token, models.TokenPurposeEmailVerification)
+	if err != nil {
+		if err == utils.ErrInvalidUserToken {
+			http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var user models.User
+	err = db.UserCollection.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": userID},
+		bson.M{"$set": bson.M{"email_verified": true}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err == nil {
+		err = promoteListedAdmin(r, &user)
+	}
+	if err != nil {
+		http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResendVerification sends a fresh verification email. It's public so users who can't log in
+// yet because of REQUIRE_EMAIL_VERIFICATION can still ask for one, and always answers Accepted.
+func ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		http.Error(w, "Email must be provided", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	err := db.UserCollection.FindOne(context.Background(),
+		bson.M{"email": utils.NormalizeEmail(body.Email)},
+		options.FindOne().SetCollation(db.CaseInsensitive),
+	).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		log.Printf("Failed to look up user for verification email: %v", err)
+	}
+	if err == nil && !user.EmailVerified && !user.Disabled {
+		if err := sendVerificationEmail(context.Background(), &user); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ID.Hex(), err)
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte("If the account exists and is unverified, a verification email has been sent"))
+}
+
+// sendVerificationEmail issues a verification token and mails the link to the user
+func sendVerificationEmail(ctx context.Context, user *models.User) error {
+	token, err := utils.CreateUserToken(ctx, user.ID, models.TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return mailer.Default.Send(ctx, verificationMessage(user.Email, token))
+}
+
+// passwordResetMessage is the email carrying a password reset link
+func passwordResetMessage(to, token string) mailer.Message {
+	return mailer.Message{
+		To:      to,
+		Subject: "Reset your Trip Planner password",
+		Body: fmt.Sprintf("Someone asked to reset the password for your account.\n\n"+
+			"Use this link within an hour to choose a new one:\n%s\n\n"+
+			"If it wasn't you, you can ignore this email.\n", appLink("/reset-password", token)),
+	}
+}
+
+// verificationMessage is the email carrying an email verification link
+func verificationMessage(to, token string) mailer.Message {
+	return mailer.Message{
+		To:      to,
+		Subject: "Confirm your Trip Planner email",
+		Body: fmt.Sprintf("Welcome to Trip Planner!\n\n"+
+			"Confirm your email address with this link:\n%s\n", appLink("/verify-email", token)),
+	}
+}
+
+// emailVerificationRequired reports whether unverified accounts are refused at login
+func emailVerificationRequired() bool {
+	return os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+}
+
+// appLink builds a link into the frontend at APP_BASE_URL carrying a token
+func appLink(path, token string) string {
+	base := os.Getenv("APP_BASE_URL")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return base + path + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"trip-planner/mailer"
+	"trip-planner/utils"
+)
+
+// smtpSink is a minimal SMTP server that accepts every message and hands it to the test
+type smtpSink struct {
+	listener net.Listener
+	messages chan *mail.Message
+}
+
+func startSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	sink := &smtpSink{listener: listener, messages: make(chan *mail.Message, 4)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	return sink
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 sink ready")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 sink")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(string(data))))
+			if err != nil {
+				text.PrintfLine("554 %v", err)
+				continue
+			}
+			s.messages <- msg
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+func (s *smtpSink) mailer() *mailer.SMTPMailer {
+	return &mailer.SMTPMailer{
+		Addr: s.listener.Addr().String(),
+		Host: "127.0.0.1",
+		From: "no-reply@trip-planner.test",
+	}
+}
+
+func (s *smtpSink) receive(t *testing.T) *mail.Message {
+	t.Helper()
+	select {
+	case msg := <-s.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message reached the SMTP sink")
+		return nil
+	}
+}
+
+var linkPattern = regexp.MustCompile(`https?://\S+`)
+
+func TestAccountEmailsOverSMTP(t *testing.T) {
+	t.Setenv("APP_BASE_URL", "https://trips.example.com")
+
+	tests := []struct {
+		name    string
+		message func(to, token string) mailer.Message
+		subject string
+		path    string
+	}{
+		{"password reset", passwordResetMessage, "Reset your Trip Planner password", "/reset-password"},
+		{"email verification", verificationMessage, "Confirm your Trip Planner email", "/verify-email"},
+	}
+
+	sink := startSMTPSink(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := utils.RandomToken(32)
+			if err != nil {
+				t.Fatalf("RandomToken: %v", err)
+			}
+			if err := sink.mailer().Send(context.Background(), tt.message("alice@example.com", token)); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			msg := sink.receive(t)
+
+			if got := msg.Header.Get("To"); got != "alice@example.com" {
+				t.Errorf("To = %q, want alice@example.com", got)
+			}
+			if got := msg.Header.Get("From"); got != "no-reply@trip-planner.test" {
+				t.Errorf("From = %q, want no-reply@trip-planner.test", got)
+			}
+			if got := msg.Header.Get("Subject"); got != tt.subject {
+				t.Errorf("Subject = %q, want %q", got, tt.subject)
+			}
+
+			body, err := io.ReadAll(msg.Body)
+			if err != nil {
+				t.Fatalf("reading body: %v", err)
+			}
+			raw := linkPattern.FindString(string(body))
+			if raw == "" {
+				t.Fatalf("no link in body %q", body)
+			}
+			link, err := url.Parse(raw)
+			if err != nil {
+				t.Fatalf("parsing link %q: %v", raw, err)
+			}
+			if link.Scheme != "https" || link.Host != "trips.example.com" || link.Path != tt.path {
+				t.Errorf("link = %q, want https://trips.example.com%s", raw, tt.path)
+			}
+
+			if got := link.Query().Get("token"); got != token {
+				t.Errorf("link token = %q, want %q", got, token)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"trip-planner/db"
+	"trip-planner/middleware"
+	"trip-planner/models"
+	"trip-planner/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListUsers returns every user, optionally filtered by ?role=
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+	filter := bson.M{}
+	if role := r.URL.Query().Get("role"); role != "" {
+		if !models.ValidRole(role) {
+			http.Error(w, "Invalid role", http.StatusBadRequest)
+			return
+		}
+		if role == models.RoleUser {
+			// Rows created before roles existed have no role field
+			filter["role"] = bson.M{"$in": []interface{}{models.RoleUser, nil}}
+		} else {
+			filter["role"] = role
+		}
+	}
+
+	cursor, err := db.UserCollection.Find(context.Background(), filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		http.Error(w, "Failed to fetch users", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	users := []models.User{}
+	if err := cursor.All(context.Background(), &users); err != nil {
+		http.Error(w, "Error while fetching users", http.StatusInternalServerError)
+		return
+	}
+	for i := range users {
+		users[i].Role = users[i].EffectiveRole()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// UpdateUserRole changes a user's role and signs them out so the new role takes effect immediately
+func UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if !models.ValidRole(body.Role) {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+	if userID == principal.UserID {
+		http.Error(w, "You cannot change your own role", http.StatusBadRequest)
+		return
+	}
+
+	updateUserAsAdmin(w, r, principal, userID, bson.M{"role": body.Role}, "user.role_change", "role "+body.Role)
+}
+
+// SetUserStatus disables or re-enables an account. Disabling revokes all of the user's tokens.
+func SetUserStatus(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	userID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Disabled *bool `json:"disabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Disabled == nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if userID == principal.UserID {
+		http.Error(w, "You cannot disable your own account", http.StatusBadRequest)
+		return
+	}
+
+	action := "user.enable"
+	if *body.Disabled {
+		action = "user.disable"
+	}
+	updateUserAsAdmin(w, r, principal, userID, bson.M{"disabled": *body.Disabled}, action, "")
+}
+
+// updateUserAsAdmin applies an admin change to a user, revokes their sessions and audits it
+func updateUserAsAdmin(w http.ResponseWriter, r *http.Request, principal *middleware.Principal, userID primitive.ObjectID, fields bson.M, action, details string) {
+	var user models.User
+	err := db.UserCollection.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": userID},
+		bson.M{"$set": fields},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "User not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := utils.RevokeAllForUser(context.Background(), userID, action); err != nil {
+		http.Error(w, "Failed to revoke user sessions", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     action,
+		TargetType: "user",
+		TargetID:   userID,
+		Details:    details,
+	})
+
+	user.Role = user.EffectiveRole()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// AdminDeleteTrip deletes any trip regardless of owner
+func AdminDeleteTrip(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	tripID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid trip ID format", http.StatusBadRequest)
+		return
+	}
+
+	trip, err := deleteTripCascade(context.Background(), bson.M{"_id": tripID})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Trip not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to delete trip", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "trip.delete",
+		TargetType: "trip",
+		TargetID:   tripID,
+		Details:    "owner " + trip.UserID.Hex(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminDeleteComment deletes any comment regardless of author
+func AdminDeleteComment(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	commentID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid comment ID format", http.StatusBadRequest)
+		return
+	}
+
+	var comment models.Comment
+	err = db.CommentCollection.FindOneAndDelete(context.Background(), bson.M{"_id": commentID}).Decode(&comment)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "comment.delete",
+		TargetType: "comment",
+		TargetID:   commentID,
+		Details:    "author " + comment.UserID.Hex(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
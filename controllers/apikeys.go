@@ -0,0 +1,245 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"trip-planner/db"
+	"trip-planner/middleware"
+	"trip-planner/models"
+	"trip-planner/policy"
+	"trip-planner/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// API key lifetimes: keys always expire, by default after 90 days and at most after a year
+const (
+	defaultAPIKeyTTL = 90 * 24 * time.Hour
+	maxAPIKeyTTL     = 365 * 24 * time.Hour
+)
+
+// CreateAPIKey creates a personal API key for the caller. The key is only returned in this response.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	createAPIKeyFor(w, r, principal.UserID)
+}
+
+// ListAPIKeys lists the caller's API keys without their secrets
+func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	cursor, err := db.APIKeyCollection.Find(context.Background(),
+		bson.M{"user_id": principal.UserID},
+		options.Find().SetSort(bson.M{"created_at": -1}),
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch API keys", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	keys := []models.APIKey{}
+	if err := cursor.All(context.Background(), &keys); err != nil {
+		http.Error(w, "Error while fetching API keys", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// RevokeAPIKey revokes one of the caller's API keys
+func RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	keyID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid API key ID format", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.APIKeyCollection.UpdateOne(context.Background(),
+		bson.M{"_id": keyID, "user_id": principal.UserID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateServiceAccount creates a password-less user for automation. It can only act through API keys.
+func CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		Username    string `json:"username"`
+		Email       string `json:"email"`
+		DisplayName string `json:"display_name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	body.Username = strings.TrimSpace(body.Username)
+	if err := utils.ValidateUsername(body.Username); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Service accounts need a unique email like everyone else; make one up if none was given
+	body.Email = utils.NormalizeEmail(body.Email)
+	if body.Email == "" {
+		body.Email = strings.ToLower(body.Username) + "@service.trip-planner.local"
+	}
+	if err := utils.ValidateEmail(body.Email); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	account := models.User{
+		ID:             primitive.NewObjectID(),
+		Email:          body.Email,
+		Username:       body.Username,
+		Role:           models.RoleUser,
+		ServiceAccount: true,
+		EmailVerified:  true,
+		DisplayName:    body.DisplayName,
+	}
+	if _, err := db.UserCollection.InsertOne(context.Background(), account); err != nil {
+		if field := duplicateUserField(err); field != "" {
+			http.Error(w, "A user with this "+field+" already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to create service account", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "service_account.create",
+		TargetType: "user",
+		TargetID:   account.ID,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(account)
+}
+
+// CreateServiceAccountAPIKey mints an API key for a service account
+func CreateServiceAccountAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	accountID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+
+	var account models.User
+	err = db.UserCollection.FindOne(context.Background(), bson.M{"_id": accountID, "service_account": true}).Decode(&account)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Service account not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve service account", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if key := createAPIKeyFor(w, r, account.ID); key != nil {
+		recordAudit(r, models.AuditEntry{
+			ActorID:    principal.UserID,
+			Action:     "api_key.create",
+			TargetType: "user",
+			TargetID:   account.ID,
+			Details:    "key " + key.ID.Hex(),
+		})
+	}
+}
+
+// createAPIKeyFor validates the request, stores a new key for ownerID and writes it to the response.
+// It returns the stored key, or nil if an error response was written.
+func createAPIKeyFor(w http.ResponseWriter, r *http.Request, ownerID primitive.ObjectID) *models.APIKey {
+	var body struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return nil
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" || len(body.Name) > 64 {
+		http.Error(w, "name must be 1-64 characters", http.StatusBadRequest)
+		return nil
+	}
+	if len(body.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return nil
+	}
+	for _, scope := range body.Scopes {
+		if !policy.ValidScope(scope) {
+			http.Error(w, "Unknown scope "+scope, http.StatusBadRequest)
+			return nil
+		}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(defaultAPIKeyTTL)
+	if body.ExpiresAt != nil {
+		if !body.ExpiresAt.After(now) || body.ExpiresAt.Sub(now) > maxAPIKeyTTL {
+			http.Error(w, "expires_at must be in the future and within a year", http.StatusBadRequest)
+			return nil
+		}
+		expiresAt = *body.ExpiresAt
+	}
+
+	plaintext, prefix, err := utils.GenerateAPIKey()
+	if err != nil {
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return nil
+	}
+
+	key := models.APIKey{
+		ID:        primitive.NewObjectID(),
+		UserID:    ownerID,
+		Name:      body.Name,
+		Prefix:    prefix,
+		KeyHash:   utils.HashToken(plaintext),
+		Scopes:    body.Scopes,
+		CreatedAt: now,
+		ExpiresAt: &expiresAt,
+	}
+	if _, err := db.APIKeyCollection.InsertOne(context.Background(), key); err != nil {
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return nil
+	}
+
+	response := struct {
+		models.APIKey
+		Key string `json:"key"`
+	}{
+		APIKey: key,
+		Key:    plaintext,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+	return &key
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"trip-planner/db"
+	"trip-planner/models"
+	"trip-planner/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Page sizes for the audit query endpoint
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// recordAudit appends an audit entry for the request, filling in the caller's IP
+func recordAudit(r *http.Request, entry models.AuditEntry) {
+	entry.IP = utils.ClientIP(r)
+	utils.RecordAudit(context.Background(), entry)
+}
+
+// ListAuditEntries returns audit entries, newest first, filtered by ?actor=, ?action=,
+// ?outcome=, ?from= and ?to= (RFC 3339). ?limit= and ?before= (an entry ID) page through them.
+func ListAuditEntries(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultAuditLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := r.URL.Query().Get("before"); value != "" {
+		before, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			http.Error(w, "Invalid before ID format", http.StatusBadRequest)
+			return
+		}
+		filter["_id"] = bson.M{"$lt": before}
+	}
+
+	cursor, err := db.AuditCollection.Find(context.Background(), filter,
+		options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit)),
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch audit entries", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	entries := []models.AuditEntry{}
+	if err := cursor.All(context.Background(), &entries); err != nil {
+		http.Error(w, "Error while fetching audit entries", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// ExportAuditEntries streams every matching entry, oldest first, as newline-delimited JSON.
+// It accepts the same filters as ListAuditEntries.
+func ExportAuditEntries(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cursor, err := db.AuditCollection.Find(r.Context(), filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		http.Error(w, "Failed to fetch audit entries", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+
+	// Headers are already sent once streaming starts, so a failure can only cut the export short
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	for count := 1; cursor.Next(r.Context()); count++ {
+		var entry models.AuditEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return
+		}
+		if flusher != nil && count%500 == 0 {
+			flusher.Flush()
+		}
+	}
+}
+
+// auditFilter builds the query shared by the audit list and export endpoints
+func auditFilter(r *http.Request) (bson.M, error) {
+	query := r.URL.Query()
+	filter := bson.M{}
+
+	if value := query.Get("actor"); value != "" {
+		actorID, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			return nil, errors.New("Invalid actor ID format")
+		}
+		filter["actor_id"] = actorID
+	}
+	if value := query.Get("action"); value != "" {
+		filter["action"] = value
+	}
+	if value := query.Get("outcome"); value != "" {
+		filter["outcome"] = value
+	}
+
+	createdAt := bson.M{}
+	if value := query.Get("from"); value != "" {
+		from, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, errors.New("from must be an RFC 3339 timestamp")
+		}
+		createdAt["$gte"] = from
+	}
+	if value := query.Get("to"); value != "" {
+		to, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, errors.New("to must be an RFC 3339 timestamp")
+		}
+		createdAt["$lt"] = to
+	}
+	if len(createdAt) > 0 {
+		filter["created_at"] = createdAt
+	}
+
+	return filter, nil
+}
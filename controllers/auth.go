@@ -3,29 +3,91 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"log"
 	"net/http"
+	"os"
+	"strings"
 	"trip-planner/db"
+	"trip-planner/middleware"
 	"trip-planner/models"
 	"trip-planner/utils"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func RegisterUser(w http.ResponseWriter, r *http.Request) {
-	var newUser models.User
-	err := json.NewDecoder(r.Body).Decode(&newUser)
+	var registration struct {
+		Email    string `json:"email"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&registration)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Insert the user into the database with plain text password
-	_, err = db.UserCollection.InsertOne(context.Background(), newUser)
+	// Normalize and validate the input before touching the database
+	registration.Email = utils.NormalizeEmail(registration.Email)
+	registration.Username = strings.TrimSpace(registration.Username)
+	for _, validationErr := range []error{
+		utils.ValidateEmail(registration.Email),
+		utils.ValidateUsername(registration.Username),
+		utils.ValidatePassword(registration.Password),
+	} {
+		if validationErr != nil {
+			http.Error(w, validationErr.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Hash the password before it ever reaches the database
+	hash, err := utils.HashPassword(registration.Password)
 	if err != nil {
 		http.Error(w, "Failed to register user", http.StatusInternalServerError)
 		return
 	}
 
+	newUser := models.User{
+		Email:    registration.Email,
+		Username: registration.Username,
+		Password: hash,
+		Role:     models.RoleUser, // promoted by promoteListedAdmin once the email is verified
+	}
+
+	// Insert the user into the database
+	result, err := db.UserCollection.InsertOne(context.Background(), newUser)
+	if err != nil {
+		if field := duplicateUserField(err); field != "" {
+			recordAudit(r, models.AuditEntry{
+				Identifier: registration.Email,
+				Action:     "user.register",
+				TargetType: "user",
+				Outcome:    models.AuditFailure,
+				Details:    "duplicate " + field,
+			})
+			http.Error(w, "A user with this "+field+" already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to register user", http.StatusInternalServerError)
+		return
+	}
+
+	// Send the verification email; a mail failure shouldn't undo the registration
+	newUser.ID, _ = result.InsertedID.(primitive.ObjectID)
+	recordAudit(r, models.AuditEntry{
+		ActorID:    newUser.ID,
+		Action:     "user.register",
+		TargetType: "user",
+		TargetID:   newUser.ID,
+	})
+	if err := sendVerificationEmail(context.Background(), &newUser); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", newUser.ID.Hex(), err)
+	}
+
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("User registered successfully"))
 }
@@ -47,46 +109,268 @@ func LoginUser(w http.ResponseWriter, r *http.Request) {
 	// Check if email or username exists
 	var user models.User
 	var filter bson.M
+	var account, identifier string
 
 	if loginDetails.Email != "" {
-		filter = bson.M{"email": loginDetails.Email}
+		identifier = utils.NormalizeEmail(loginDetails.Email)
+		filter = bson.M{"email": identifier}
+		account = "email:" + identifier
 	} else if loginDetails.Username != "" {
-		filter = bson.M{"username": loginDetails.Username}
+		identifier = strings.TrimSpace(loginDetails.Username)
+		filter = bson.M{"username": identifier}
+		account = "username:" + strings.ToLower(identifier)
 	} else {
 		http.Error(w, "Email or Username must be provided", http.StatusBadRequest)
 		return
 	}
 
 	// Find the user in the database
-	err = db.UserCollection.FindOne(context.Background(), filter).Decode(&user)
-	if err != nil {
-		http.Error(w, "User not found", http.StatusUnauthorized)
+	err = db.UserCollection.FindOne(context.Background(), filter, options.FindOne().SetCollation(db.CaseInsensitive)).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
 		return
 	}
+	found := err == nil
+	if found {
+		// Count failures per user so email and username attempts share one budget
+		account = "user:" + user.ID.Hex()
+	}
 
-	// Compare the plaintext password
-	if user.Password != loginDetails.Password {
+	// Refuse while the IP or account is locked out
+	ip := utils.ClientIP(r)
+	if wait := loginLockout(context.Background(), ip, account); wait > 0 {
+		auditLogin(r, user.ID, identifier, models.AuditFailure, "locked out")
+		setRetryAfter(w, wait)
+		http.Error(w, "Too many login attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	// Verify the password against the stored hash (or legacy plaintext value). Unknown
+	// accounts get the same answer and timing as a wrong password.
+	if !found {
+		utils.SimulatePasswordCheck(loginDetails.Password)
+	}
+	if !found || !utils.VerifyPassword(user.Password, loginDetails.Password) {
+		if wait := recordLoginFailure(context.Background(), ip, account); wait > 0 {
+			setRetryAfter(w, wait)
+		}
+		auditLogin(r, user.ID, identifier, models.AuditFailure, "invalid credentials")
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Generate a JWT token using MongoDB's ObjectID as the user identifier
-	token, err := utils.GenerateJWT(user.ID.Hex()) // Use Hex() to convert ObjectID to string
+	// Disabled accounts can't sign in
+	if user.Disabled {
+		auditLogin(r, user.ID, identifier, models.AuditFailure, "account disabled")
+		http.Error(w, "Account disabled", http.StatusForbidden)
+		return
+	}
+
+	// Optionally refuse accounts that haven't confirmed their email
+	if emailVerificationRequired() && !user.EmailVerified {
+		auditLogin(r, user.ID, identifier, models.AuditFailure, "email not verified")
+		http.Error(w, "Email not verified", http.StatusForbidden)
+		return
+	}
+
+	// Upgrade legacy plaintext or weaker hashes now that we know the password
+	if utils.PasswordNeedsRehash(user.Password) {
+		rehashPassword(user.ID, loginDetails.Password)
+	}
+
+	// Only a fully completed login clears the failure count, otherwise knowing the password
+	// would reset the budget for guessing second-factor codes
+	if !user.MFAEnabled {
+		recordLoginSuccess(context.Background(), account)
+		auditLogin(r, user.ID, identifier, models.AuditSuccess, "")
+	} else {
+		auditLogin(r, user.ID, identifier, models.AuditSuccess, "password accepted, second factor pending")
+	}
+
+	respondWithLogin(w, r, &user)
+}
+
+// auditLogin records a login attempt. The user ID is nil when the identifier matched no account.
+func auditLogin(r *http.Request, userID primitive.ObjectID, identifier, outcome, details string) {
+	recordAudit(r, models.AuditEntry{
+		ActorID:    userID,
+		Identifier: identifier,
+		Action:     "user.login",
+		TargetType: "user",
+		TargetID:   userID,
+		Outcome:    outcome,
+		Details:    details,
+	})
+}
+
+// respondWithLogin finishes a successful first factor. With two-factor authentication it only
+// hands out a short-lived "mfa pending" token, the real tokens are issued by LoginMFA once a
+// code is provided. Otherwise it starts a new token family.
+func respondWithLogin(w http.ResponseWriter, r *http.Request, user *models.User) {
+	if user.MFAEnabled {
+		mfaToken, err := utils.GenerateMFAToken(user.ID.Hex())
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		response := struct {
+			MFARequired bool   `json:"mfa_required"`
+			MFAToken    string `json:"mfa_token"`
+			ExpiresIn   int64  `json:"expires_in"`
+		}{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int64(utils.MFATokenTTL.Seconds()),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// Start a new token family with a short-lived access token and a refresh token
+	tokens, err := utils.IssueTokenPair(context.Background(), user, utils.ClientInfoFromRequest(r))
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	// Respond with the token
-	response := struct {
-		Token string `json:"token"`
-	}{
-		Token: token,
+	// Set response header for JSON and send the tokens
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// RefreshToken exchanges a refresh token for a new access and refresh token pair
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		http.Error(w, "Refresh token must be provided", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := utils.RotateRefreshToken(context.Background(), body.RefreshToken, utils.ClientInfoFromRequest(r))
+	if err != nil {
+		if err == utils.ErrInvalidRefreshToken || err == utils.ErrRefreshTokenReused {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		} else {
+			http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+		}
+		return
 	}
 
-	// Set response header for JSON and send the token
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// Logout revokes the caller's access token and the refresh token family it came from
+func Logout(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	if principal == nil {
+		middleware.Unauthorized(w)
+		return
+	}
+	claims, userID := principal.Claims, principal.UserID
+
+	err := utils.RevokeAccessToken(context.Background(), claims, "logout")
+	if err == nil && claims.SessionID != "" {
+		err = utils.RevokeFamily(context.Background(), userID, claims.SessionID, "logout")
+	}
+	if err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutAll revokes every token family the caller has, signing them out everywhere
+func LogoutAll(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	if principal == nil {
+		middleware.Unauthorized(w)
+		return
+	}
+	claims, userID := principal.Claims, principal.UserID
+
+	err := utils.RevokeAccessToken(context.Background(), claims, "logout-all")
+	if err == nil {
+		err = utils.RevokeAllForUser(context.Background(), userID, "logout-all")
+	}
+	if err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// duplicateUserField names the unique user field a duplicate key error was raised for,
+// or returns "" if err isn't a duplicate key error
+func duplicateUserField(err error) string {
+	if !mongo.IsDuplicateKeyError(err) {
+		return ""
+	}
+	switch {
+	case strings.Contains(err.Error(), "email_unique"):
+		return "email"
+	case strings.Contains(err.Error(), "username_unique"):
+		return "username"
+	default:
+		return "email or username"
+	}
+}
+
+// initialRole grants admin to addresses listed in ADMIN_EMAILS so a fresh install
+// can bootstrap its first administrator; everyone else starts as a plain user.
+// Only call it for addresses that have been verified.
+func initialRole(email string) string {
+	for _, admin := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		admin = strings.TrimSpace(admin)
+		if admin != "" && strings.EqualFold(admin, email) {
+			return models.RoleAdmin
+		}
+	}
+	return models.RoleUser
+}
+
+// promoteListedAdmin makes the user an admin if their just-verified email is listed in
+// ADMIN_EMAILS. Only plain users are promoted, so a role changed by hand is left alone.
+func promoteListedAdmin(r *http.Request, user *models.User) error {
+	if initialRole(user.Email) != models.RoleAdmin || user.EffectiveRole() != models.RoleUser {
+		return nil
+	}
+	result, err := db.UserCollection.UpdateOne(context.Background(),
+		bson.M{"_id": user.ID, "role": bson.M{"$in": bson.A{models.RoleUser, nil}}},
+		bson.M{"$set": bson.M{"role": models.RoleAdmin}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		user.Role = models.RoleAdmin
+		recordAudit(r, models.AuditEntry{
+			ActorID:    user.ID,
+			Action:     "user.role_change",
+			TargetType: "user",
+			TargetID:   user.ID,
+			Details:    "role admin via ADMIN_EMAILS",
+		})
+	}
+	return nil
 }
 
+// rehashPassword replaces the stored password with a fresh hash. Failures are
+// logged but don't block the login, the upgrade is retried on the next sign-in.
+func rehashPassword(userID primitive.ObjectID, password string) {
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		log.Printf("Failed to rehash password for user %s: %v", userID.Hex(), err)
+		return
+	}
+	_, err = db.UserCollection.UpdateOne(context.Background(), bson.M{"_id": userID}, bson.M{"$set": bson.M{"password": hash}})
+	if err != nil {
+		log.Printf("Failed to store rehashed password for user %s: %v", userID.Hex(), err)
+	}
+}
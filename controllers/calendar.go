@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"trip-planner/db"
+	"trip-planner/middleware"
+	"trip-planner/models"
+	"trip-planner/policy"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetCalendarConflicts lists every pair of the caller's trips that overlap in time, earliest
+// first. Cancelled and undated trips are left out. ?from= and ?to= limit the trips considered
+// as in GetTrips.
+func GetCalendarConflicts(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	filter := bson.M{"$or": tripMemberClauses(principal.UserID, models.TripRoleViewer)}
+	if err := addScheduleFilters(filter, r.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := filter["status"]; !ok {
+		filter["status"] = bson.M{"$ne": models.TripStatusCancelled}
+	}
+	filter["starts_at"] = bson.M{"$exists": true}
+
+	var trips []models.Trip
+	cursor, err := db.TripCollection.Find(context.Background(), filter,
+		options.Find().SetSort(bson.D{{Key: "starts_at", Value: 1}}))
+	if err == nil {
+		err = cursor.All(context.Background(), &trips)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch trips", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(findOverlaps(trips))
+}
+
+// findOverlaps returns the overlapping pairs among dated trips sorted by start
+func findOverlaps(trips []models.Trip) []models.TripOverlap {
+	sort.SliceStable(trips, func(i, j int) bool { return trips[i].StartsAt.Before(*trips[j].StartsAt) })
+
+	overlaps := []models.TripOverlap{}
+	for i := range trips {
+		for j := i + 1; j < len(trips) && trips[j].StartsAt.Before(*trips[i].EndsAt); j++ {
+			end := *trips[i].EndsAt
+			if trips[j].EndsAt.Before(end) {
+				end = *trips[j].EndsAt
+			}
+			overlaps = append(overlaps, models.TripOverlap{
+				First:        trips[i].Summary(),
+				Second:       trips[j].Summary(),
+				OverlapStart: *trips[j].StartsAt,
+				OverlapEnd:   end,
+			})
+		}
+	}
+	return overlaps
+}
+
+// findTripConflicts looks for other trips that overlap the given one and share any of its
+// members, the creator included. It returns one warning per double-booked member and trip,
+// naming the other trip only when the principal could view it.
+func findTripConflicts(ctx context.Context, principal *middleware.Principal, trip *models.Trip) ([]models.TripConflict, error) {
+	if trip.StartsAt == nil || trip.EndsAt == nil || trip.Status == models.TripStatusCancelled {
+		return nil, nil
+	}
+
+	userIDs := []primitive.ObjectID{trip.UserID}
+	for _, member := range trip.Members {
+		userIDs = append(userIDs, member.UserID)
+	}
+
+	var others []models.Trip
+	cursor, err := db.TripCollection.Find(ctx, bson.M{
+		"_id":       bson.M{"$ne": trip.ID},
+		"status":    bson.M{"$ne": models.TripStatusCancelled},
+		"starts_at": bson.M{"$lt": trip.EndsAt},
+		"ends_at":   bson.M{"$gt": trip.StartsAt},
+		"$or": bson.A{
+			bson.M{"user_id": bson.M{"$in": userIDs}},
+			bson.M{"members.user_id": bson.M{"$in": userIDs}},
+		},
+	}, options.Find().SetSort(bson.D{{Key: "starts_at", Value: 1}}))
+	if err == nil {
+		err = cursor.All(ctx, &others)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []models.TripConflict
+	for _, other := range others {
+		start, end := *other.StartsAt, *other.EndsAt
+		if trip.StartsAt.After(start) {
+			start = *trip.StartsAt
+		}
+		if trip.EndsAt.Before(end) {
+			end = *trip.EndsAt
+		}
+		visible := policy.CanAccessTrip(principal.UserID, principal.Role, &other, models.TripRoleViewer)
+		for _, userID := range userIDs {
+			if other.MemberRole(userID) == "" {
+				continue
+			}
+			conflict := models.TripConflict{UserID: userID, OverlapStart: start, OverlapEnd: end}
+			if visible {
+				otherID := other.ID
+				conflict.TripID = &otherID
+				conflict.TripName = other.Name
+				conflict.StartsAt = other.StartsAt
+				conflict.EndsAt = other.EndsAt
+			}
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	return conflicts, nil
+}
+
+// attachTripConflicts fills in the trip's overlap warnings. They are advisory, so a failed
+// lookup is only logged.
+func attachTripConflicts(principal *middleware.Principal, trip *models.Trip) {
+	conflicts, err := findTripConflicts(context.Background(), principal, trip)
+	if err != nil {
+		log.Printf("Failed to check trip %s for conflicts: %v", trip.ID.Hex(), err)
+		return
+	}
+	trip.Conflicts = conflicts
+}
@@ -6,8 +6,9 @@ import (
 	"net/http"
 	"time"
 	"trip-planner/db"
+	"trip-planner/middleware"
 	"trip-planner/models"
-	"trip-planner/utils"
+	"trip-planner/policy"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
@@ -31,24 +32,21 @@ func CreateComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the user ID from JWT token
-	token := r.Header.Get("Authorization")
-	claims, err := utils.ValidateJWT(token)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// Get the caller from the request context
+	principal := middleware.CurrentPrincipal(r)
+	if principal == nil {
+		middleware.Unauthorized(w)
 		return
 	}
 
-	// Convert userID from string to ObjectID
-	userID, err := primitive.ObjectIDFromHex(claims.UserID)
-	if err != nil {
-		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+	// Only people who can see the trip may comment on it
+	if !checkCommentTrip(w, principal, objectID, false) {
 		return
 	}
 
 	// Set the trip ID and user ID in the comment
 	comment.TripID = objectID
-	comment.UserID = userID
+	comment.UserID = principal.UserID
 	comment.ID = primitive.NewObjectID() // Automatically generate a new ObjectID for the comment
 
 	// Insert the comment into the database
@@ -63,9 +61,14 @@ func CreateComment(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(comment)
 }
 
-// GetCommentByID retrieves a comment by its ID
+// GetCommentByID retrieves a comment by its ID. The comment must belong to the trip in the path.
 func GetCommentByID(w http.ResponseWriter, r *http.Request) {
-	// Get the comment ID from the URL parameters
+	// Get the trip and comment IDs from the URL parameters
+	tripID, err := primitive.ObjectIDFromHex(mux.Vars(r)["trip_id"])
+	if err != nil {
+		http.Error(w, "Invalid trip ID format", http.StatusBadRequest)
+		return
+	}
 	commentID := mux.Vars(r)["id"]
 
 	// Convert the comment ID from string to ObjectID
@@ -75,9 +78,14 @@ func GetCommentByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The caller may be anonymous, in which case only open trips are readable
+	if !checkCommentTrip(w, middleware.CurrentPrincipal(r), tripID, false) {
+		return
+	}
+
 	// Find the comment in the database by its ObjectID
 	var comment models.Comment
-	err = db.CommentCollection.FindOne(context.Background(), bson.M{"_id": objectID}).Decode(&comment)
+	err = db.CommentCollection.FindOne(context.Background(), bson.M{"_id": objectID, "trip_id": tripID}).Decode(&comment)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			http.Error(w, "Comment not found", http.StatusNotFound)
@@ -101,6 +109,11 @@ func GetComments(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The caller may be anonymous, in which case only open trips are readable
+	if !checkCommentTrip(w, middleware.CurrentPrincipal(r), objectID, false) {
+		return
+	}
+
 	var comments []models.Comment
 	cursor, err := db.CommentCollection.Find(context.Background(), bson.M{"trip_id": objectID})
 	if err != nil {
@@ -129,7 +142,12 @@ func GetComments(w http.ResponseWriter, r *http.Request) {
 }
 
 func UpdateComment(w http.ResponseWriter, r *http.Request) {
-	// Get the comment ID from the URL
+	// Get the trip and comment IDs from the URL
+	tripID, err := primitive.ObjectIDFromHex(mux.Vars(r)["trip_id"])
+	if err != nil {
+		http.Error(w, "Invalid trip ID format", http.StatusBadRequest)
+		return
+	}
 	commentID := mux.Vars(r)["id"]
 
 	// Convert the comment ID from string to ObjectID
@@ -152,47 +170,49 @@ func UpdateComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the user ID from the JWT token
-	token := r.Header.Get("Authorization")
-	claims, err := utils.ValidateJWT(token)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// Comments stay on the trip they were written on
+	if !updatedComment.TripID.IsZero() && updatedComment.TripID != tripID {
+		http.Error(w, "Comments can't be moved to another trip", http.StatusBadRequest)
 		return
 	}
 
-	// Convert userID from string to ObjectID
-	userID, err := primitive.ObjectIDFromHex(claims.UserID)
-	if err != nil {
-		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+	// Get the caller from the request context
+	principal := middleware.CurrentPrincipal(r)
+	if principal == nil {
+		middleware.Unauthorized(w)
 		return
 	}
 
-	// Ensure the comment belongs to the user and validate if the comment exists
-	filter := bson.M{
-		"_id":     objectID,
-		"user_id": userID,
+	// Authors must still be able to see the trip; moderators may reach any trip
+	if !checkCommentTrip(w, principal, tripID, true) {
+		return
 	}
 
-	// Get the current comment from the database to preserve the trip_id if not provided in the update
+	// Ensure the comment is on this trip and belongs to the user (or the caller may moderate)
+	filter := commentAccessFilter(objectID, principal)
+	filter["trip_id"] = tripID
+
+	// Get the current comment from the database to check it exists and preserve its author
 	var currentComment models.Comment
 	err = db.CommentCollection.FindOne(context.Background(), filter).Decode(&currentComment)
 	if err != nil {
+		recordAudit(r, models.AuditEntry{
+			ActorID:    principal.UserID,
+			Action:     "comment.update",
+			TargetType: "comment",
+			TargetID:   objectID,
+			Outcome:    models.AuditFailure,
+			Details:    "not found or not permitted",
+		})
 		http.Error(w, "Comment not found or you don't have permission", http.StatusNotFound)
 		return
 	}
 
-	// If trip_id is provided in the request, we will use that; otherwise, we keep the existing one.
-	tripID := currentComment.TripID
-	if updatedComment.TripID != primitive.NilObjectID {
-		tripID = updatedComment.TripID // If new trip_id is provided, update it
-	}
-
 	// Update the comment in the database
 	update := bson.M{
 		"$set": bson.M{
 			"content":    updatedComment.Content, // Update only the content
 			"updated_at": primitive.NewDateTimeFromTime(time.Now()), // Optional: Update timestamp
-			"trip_id":    tripID, // Ensure trip_id is preserved or updated
 		},
 	}
 
@@ -209,10 +229,18 @@ func UpdateComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "comment.update",
+		TargetType: "comment",
+		TargetID:   objectID,
+		Details:    "author " + currentComment.UserID.Hex(),
+	})
+
 	// Return the updated comment as a JSON response
 	updatedComment.ID = objectID // Ensure ID is set for the response
-	updatedComment.UserID = userID // Preserve the userID in the response
-	updatedComment.TripID = tripID // Ensure trip_id is preserved
+	updatedComment.UserID = currentComment.UserID // Preserve the author in the response
+	updatedComment.TripID = tripID // The comment stays on its trip
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(updatedComment)
@@ -223,6 +251,11 @@ func UpdateComment(w http.ResponseWriter, r *http.Request) {
 
 // DeleteComment deletes a comment by its ID
 func DeleteComment(w http.ResponseWriter, r *http.Request) {
+	tripID, err := primitive.ObjectIDFromHex(mux.Vars(r)["trip_id"])
+	if err != nil {
+		http.Error(w, "Invalid trip ID format", http.StatusBadRequest)
+		return
+	}
 	commentID := mux.Vars(r)["id"]
 	objectID, err := primitive.ObjectIDFromHex(commentID)
 	if err != nil {
@@ -230,30 +263,85 @@ func DeleteComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the user ID from JWT token
-	token := r.Header.Get("Authorization")
-	claims, err := utils.ValidateJWT(token)
-	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	// Get the caller from the request context
+	principal := middleware.CurrentPrincipal(r)
+	if principal == nil {
+		middleware.Unauthorized(w)
 		return
 	}
 
-	// Convert userID from string to ObjectID
-	userID, err := primitive.ObjectIDFromHex(claims.UserID)
-	if err != nil {
-		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+	// Authors must still be able to see the trip; moderators may reach any trip
+	if !checkCommentTrip(w, principal, tripID, true) {
 		return
 	}
 
-	// Ensure the comment belongs to the user
-	filter := bson.M{"_id": objectID, "user_id": userID}
+	// Ensure the comment is on this trip and belongs to the user, unless the caller may moderate comments
+	filter := commentAccessFilter(objectID, principal)
+	filter["trip_id"] = tripID
 
 	// Delete the comment from the database
-	_, err = db.CommentCollection.DeleteOne(context.Background(), filter)
-	if err != nil {
+	var deletedComment models.Comment
+	err = db.CommentCollection.FindOneAndDelete(context.Background(), filter).Decode(&deletedComment)
+	if err != nil && err != mongo.ErrNoDocuments {
 		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
 		return
 	}
 
+	if err == mongo.ErrNoDocuments {
+		recordAudit(r, models.AuditEntry{
+			ActorID:    principal.UserID,
+			Action:     "comment.delete",
+			TargetType: "comment",
+			TargetID:   objectID,
+			Outcome:    models.AuditFailure,
+			Details:    "not found or not permitted",
+		})
+	} else {
+		recordAudit(r, models.AuditEntry{
+			ActorID:    principal.UserID,
+			Action:     "comment.delete",
+			TargetType: "comment",
+			TargetID:   objectID,
+			Details:    "author " + deletedComment.UserID.Hex(),
+		})
+	}
+
 	w.WriteHeader(http.StatusNoContent) // No content as response after successful deletion
 }
+
+// commentAccessFilter limits a comment lookup to the caller's own comments unless their role may moderate
+func commentAccessFilter(commentID primitive.ObjectID, principal *middleware.Principal) bson.M {
+	filter := bson.M{"_id": commentID}
+	if !policy.Can(principal.Role, policy.ModerateComments) {
+		filter["user_id"] = principal.UserID
+	}
+	return filter
+}
+
+// checkCommentTrip checks the trip a comment operation targets exists and the caller may see it,
+// writing a 404 otherwise so private trips don't reveal they exist. principal is nil for
+// anonymous callers. With moderate set, callers who may moderate comments reach any trip.
+func checkCommentTrip(w http.ResponseWriter, principal *middleware.Principal, tripID primitive.ObjectID, moderate bool) bool {
+	var trip models.Trip
+	err := db.TripCollection.FindOne(context.Background(), bson.M{"_id": tripID}).Decode(&trip)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Trip not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve trip", http.StatusInternalServerError)
+		}
+		return false
+	}
+
+	allowed := trip.OpenToNonMembers()
+	if principal != nil {
+		allowed = allowed ||
+			policy.CanAccessTrip(principal.UserID, principal.Role, &trip, models.TripRoleViewer) ||
+			moderate && policy.Can(principal.Role, policy.ModerateComments)
+	}
+	if !allowed {
+		http.Error(w, "Trip not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
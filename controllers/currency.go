@@ -0,0 +1,347 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"trip-planner/db"
+	"trip-planner/middleware"
+	"trip-planner/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxRateImportSize caps the size of an uploaded rate file
+const maxRateImportSize = 10 << 20
+
+// ListCurrencies returns the supported ISO 4217 currencies and their decimal places
+func ListCurrencies(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.Currencies())
+}
+
+// ListExchangeRates returns stored rates, newest first. ?base= and ?currency= narrow the list
+// to a currency pair, and ?date= to the rates in effect on that day.
+func ListExchangeRates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := bson.M{}
+	for _, field := range []string{"base", "currency"} {
+		if value := query.Get(field); value != "" {
+			filter[field] = strings.ToUpper(value)
+		}
+	}
+
+	rates := []models.ExchangeRate{}
+	cursor, err := db.ExchangeRateCollection.Find(context.Background(), filter,
+		options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "base", Value: 1}, {Key: "currency", Value: 1}}))
+	if err == nil {
+		err = cursor.All(context.Background(), &rates)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch exchange rates", http.StatusInternalServerError)
+		return
+	}
+
+	if date := query.Get("date"); date != "" {
+		if _, err := time.Parse(models.TripDateLayout, date); err != nil {
+			http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		// Keep the newest rate on or before the date for each pair
+		effective := []models.ExchangeRate{}
+		seen := map[[2]string]bool{}
+		for _, rate := range rates {
+			pair := [2]string{rate.Base, rate.Currency}
+			if rate.Date <= date && !seen[pair] {
+				seen[pair] = true
+				effective = append(effective, rate)
+			}
+		}
+		rates = effective
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rates)
+}
+
+// ImportExchangeRates loads rates from an uploaded file, sent as the request body. A text/csv
+// body needs a header row naming the base, currency, rate and date columns; anything else is
+// read as a JSON array of rates. The file is checked as a whole before anything is stored,
+// and a rate for a pair and date already in the table replaces it.
+func ImportExchangeRates(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	body := http.MaxBytesReader(w, r.Body, maxRateImportSize)
+	var rates []models.ExchangeRate
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/csv" {
+		rates, err = parseRateCSV(body)
+	} else {
+		rates, err = parseRateJSON(body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(rates) == 0 {
+		http.Error(w, "The file has no rates", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	writes := make([]mongo.WriteModel, 0, len(rates))
+	for _, rate := range rates {
+		rate.ImportedBy = principal.UserID
+		rate.ImportedAt = now
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"base": rate.Base, "currency": rate.Currency, "date": rate.Date}).
+			SetUpdate(bson.M{
+				"$set": bson.M{"rate": rate.Rate, "imported_by": rate.ImportedBy, "imported_at": rate.ImportedAt},
+			}).
+			SetUpsert(true))
+	}
+	result, err := db.ExchangeRateCollection.BulkWrite(context.Background(), writes)
+	if err != nil {
+		http.Error(w, "Failed to import exchange rates", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "currency.rates_import",
+		TargetType: "exchange_rate",
+		Details:    fmt.Sprintf("%d added, %d replaced", result.UpsertedCount, result.ModifiedCount),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{
+		"imported": int64(len(rates)),
+		"added":    result.UpsertedCount,
+		"replaced": result.ModifiedCount,
+	})
+}
+
+// DeleteExchangeRate removes a stored rate
+func DeleteExchangeRate(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	rateID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid rate ID format", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.ExchangeRateCollection.DeleteOne(context.Background(), bson.M{"_id": rateID})
+	if err != nil {
+		http.Error(w, "Failed to delete exchange rate", http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		http.Error(w, "Exchange rate not found", http.StatusNotFound)
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "currency.rate_delete",
+		TargetType: "exchange_rate",
+		TargetID:   rateID,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseRateCSV reads rates from CSV with a header row. Columns may come in any order and
+// unknown columns are ignored.
+func parseRateCSV(body io.Reader) ([]models.ExchangeRate, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("The file must start with a header row")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"base", "currency", "rate", "date"} {
+		if _, ok := columns[name]; !ok {
+			return nil, errors.New("The header row must name a " + name + " column")
+		}
+	}
+
+	var rates []models.ExchangeRate
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %v", line, err)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[columns["rate"]]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: rate must be a number", line)
+		}
+		rate := models.ExchangeRate{
+			Base:     record[columns["base"]],
+			Currency: record[columns["currency"]],
+			Rate:     value,
+			Date:     strings.TrimSpace(record[columns["date"]]),
+		}
+		if err := validateExchangeRate(&rate); err != nil {
+			return nil, fmt.Errorf("Line %d: %v", line, err)
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+// parseRateJSON reads rates from a JSON array
+func parseRateJSON(body io.Reader) ([]models.ExchangeRate, error) {
+	var rates []models.ExchangeRate
+	if err := json.NewDecoder(body).Decode(&rates); err != nil {
+		return nil, errors.New("The file must be a JSON array of rates")
+	}
+	for i := range rates {
+		rates[i].ID = primitive.NilObjectID
+		if err := validateExchangeRate(&rates[i]); err != nil {
+			return nil, fmt.Errorf("Rate %d: %v", i+1, err)
+		}
+	}
+	return rates, nil
+}
+
+// validateExchangeRate normalises the currency codes of a rate and checks it
+func validateExchangeRate(rate *models.ExchangeRate) error {
+	rate.Base = strings.ToUpper(strings.TrimSpace(rate.Base))
+	rate.Currency = strings.ToUpper(strings.TrimSpace(rate.Currency))
+	if !models.ValidCurrencyCode(rate.Base) || !models.ValidCurrencyCode(rate.Currency) {
+		return errors.New("base and currency must be ISO 4217 currency codes")
+	}
+	if rate.Base == rate.Currency {
+		return errors.New("base and currency must differ")
+	}
+	if math.IsNaN(rate.Rate) || math.IsInf(rate.Rate, 0) || rate.Rate <= 0 {
+		return errors.New("rate must be greater than zero")
+	}
+	if _, err := time.Parse(models.TripDateLayout, rate.Date); err != nil {
+		return errors.New("date must be YYYY-MM-DD")
+	}
+	return nil
+}
+
+// rateBook holds the stored rates for a set of currencies, oldest first for each pair
+type rateBook struct {
+	pairs      map[[2]string][]models.ExchangeRate
+	currencies []string
+}
+
+// newRateBook groups rates, sorted oldest first, by pair
+func newRateBook(rates []models.ExchangeRate) *rateBook {
+	book := &rateBook{pairs: map[[2]string][]models.ExchangeRate{}}
+	seen := map[string]bool{}
+	for _, rate := range rates {
+		pair := [2]string{rate.Base, rate.Currency}
+		book.pairs[pair] = append(book.pairs[pair], rate)
+		for _, code := range pair {
+			if !seen[code] {
+				seen[code] = true
+				book.currencies = append(book.currencies, code)
+			}
+		}
+	}
+	sort.Strings(book.currencies)
+	return book
+}
+
+// loadRateBook fetches every rate that involves one of the currencies, which is enough to
+// convert between them directly or through one other currency
+func loadRateBook(ctx context.Context, currencies []string) (*rateBook, error) {
+	if len(currencies) == 0 {
+		return newRateBook(nil), nil
+	}
+
+	var rates []models.ExchangeRate
+	cursor, err := db.ExchangeRateCollection.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"base": bson.M{"$in": currencies}},
+		bson.M{"currency": bson.M{"$in": currencies}},
+	}}, options.Find().SetSort(bson.D{{Key: "date", Value: 1}}))
+	if err == nil {
+		err = cursor.All(ctx, &rates)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newRateBook(rates), nil
+}
+
+// convert changes an amount from one currency to another at the rate in effect on date,
+// rounded to the decimal places of the target currency. It reports false when no rate is known.
+func (b *rateBook) convert(amount float64, from, to, date string) (float64, bool) {
+	rate, ok := b.rate(from, to, date)
+	if !ok {
+		return 0, false
+	}
+	return models.RoundAmount(amount*rate, to), true
+}
+
+// rate finds how many units of to one unit of from buys on date: from a rate for the pair,
+// the inverse of a rate for the reverse pair, or failing both through a third currency
+func (b *rateBook) rate(from, to, date string) (float64, bool) {
+	if from == to {
+		return 1, true
+	}
+	if rate, ok := b.pairRate(from, to, date); ok {
+		return rate, true
+	}
+	for _, via := range b.currencies {
+		if via == from || via == to {
+			continue
+		}
+		first, ok := b.pairRate(from, via, date)
+		if !ok {
+			continue
+		}
+		if second, ok := b.pairRate(via, to, date); ok {
+			return first * second, true
+		}
+	}
+	return 0, false
+}
+
+// pairRate looks up the rate in effect on date stored for the pair either way round. When both
+// directions have one the later dated wins, and the direct rate on a tie.
+func (b *rateBook) pairRate(from, to, date string) (float64, bool) {
+	direct, hasDirect := effectiveRate(b.pairs[[2]string{from, to}], date)
+	inverse, hasInverse := effectiveRate(b.pairs[[2]string{to, from}], date)
+	switch {
+	case hasInverse && (!hasDirect || inverse.Date > direct.Date):
+		return 1 / inverse.Rate, true
+	case hasDirect:
+		return direct.Rate, true
+	}
+	return 0, false
+}
+
+// effectiveRate returns the newest of the rates, sorted oldest first, dated on or before date
+func effectiveRate(rates []models.ExchangeRate, date string) (models.ExchangeRate, bool) {
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].Date > date })
+	if i == 0 {
+		return models.ExchangeRate{}, false
+	}
+	return rates[i-1], true
+}
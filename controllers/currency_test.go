@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"math"
+	"testing"
+	"trip-planner/models"
+)
+
+func TestEffectiveRate(t *testing.T) {
+	rates := []models.ExchangeRate{
+		{Base: "EUR", Currency: "USD", Rate: 1.10, Date: "2026-01-01"},
+		{Base: "EUR", Currency: "USD", Rate: 1.12, Date: "2026-02-01"},
+		{Base: "EUR", Currency: "USD", Rate: 1.08, Date: "2026-03-15"},
+	}
+	tests := []struct {
+		date   string
+		want   float64
+		wantOK bool
+	}{
+		{date: "2025-12-31", wantOK: false},
+		{date: "2026-01-01", want: 1.10, wantOK: true},
+		{date: "2026-01-31", want: 1.10, wantOK: true},
+		{date: "2026-02-01", want: 1.12, wantOK: true},
+		{date: "2026-03-14", want: 1.12, wantOK: true},
+		{date: "2026-12-31", want: 1.08, wantOK: true},
+	}
+	for _, tt := range tests {
+		rate, ok := effectiveRate(rates, tt.date)
+		if got := rate.Rate; ok != tt.wantOK || got != tt.want {
+			t.Errorf("effectiveRate on %s = %v, %v; want %v, %v", tt.date, rate.Rate, ok, tt.want, tt.wantOK)
+		}
+	}
+	if _, ok := effectiveRate(nil, "2026-01-01"); ok {
+		t.Error("effectiveRate found a rate in an empty list")
+	}
+}
+
+func TestRateBookRate(t *testing.T) {
+	book := newRateBook([]models.ExchangeRate{
+		{Base: "EUR", Currency: "USD", Rate: 1.25, Date: "2026-01-01"},
+		{Base: "EUR", Currency: "USD", Rate: 1.10, Date: "2026-06-01"},
+		{Base: "USD", Currency: "JPY", Rate: 150, Date: "2026-01-01"},
+		{Base: "GBP", Currency: "CHF", Rate: 1.1, Date: "2026-01-01"},
+		{Base: "USD", Currency: "EUR", Rate: 0.8, Date: "2026-09-01"},
+	})
+	tests := []struct {
+		name     string
+		from, to string
+		date     string
+		want     float64
+		wantOK   bool
+	}{
+		{name: "same currency", from: "EUR", to: "EUR", date: "2020-01-01", want: 1, wantOK: true},
+		{name: "direct", from: "EUR", to: "USD", date: "2026-03-01", want: 1.25, wantOK: true},
+		{name: "direct by date", from: "EUR", to: "USD", date: "2026-06-01", want: 1.10, wantOK: true},
+		{name: "inverse", from: "USD", to: "EUR", date: "2026-03-01", want: 0.8, wantOK: true},
+		{name: "inverse by date", from: "USD", to: "EUR", date: "2026-07-01", want: 1 / 1.10, wantOK: true},
+		{name: "newer inverse wins", from: "EUR", to: "USD", date: "2026-10-01", want: 1.25, wantOK: true},
+		{name: "newer direct wins", from: "USD", to: "EUR", date: "2026-10-01", want: 0.8, wantOK: true},
+		{name: "older inverse loses", from: "USD", to: "EUR", date: "2026-08-01", want: 1 / 1.10, wantOK: true},
+		{name: "one hop", from: "EUR", to: "JPY", date: "2026-03-01", want: 187.5, wantOK: true},
+		{name: "one hop inverse", from: "JPY", to: "EUR", date: "2026-03-01", want: 1 / 187.5, wantOK: true},
+		{name: "before any rate", from: "EUR", to: "USD", date: "2025-12-31", wantOK: false},
+		{name: "unconnected", from: "EUR", to: "CHF", date: "2026-03-01", wantOK: false},
+		{name: "unknown currency", from: "EUR", to: "AUD", date: "2026-03-01", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := book.rate(tt.from, tt.to, tt.date)
+			if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("rate(%s, %s, %s) = %v, %v; want %v, %v", tt.from, tt.to, tt.date, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRateBookConvert(t *testing.T) {
+	book := newRateBook([]models.ExchangeRate{
+		{Base: "USD", Currency: "JPY", Rate: 150, Date: "2026-01-01"},
+		{Base: "USD", Currency: "EUR", Rate: 0.9, Date: "2026-01-01"},
+		{Base: "USD", Currency: "KWD", Rate: 0.3071, Date: "2026-01-01"},
+	})
+	tests := []struct {
+		amount   float64
+		from, to string
+		want     float64
+	}{
+		{amount: 1000, from: "JPY", to: "EUR", want: 6},
+		{amount: 10, from: "USD", to: "JPY", want: 1500},
+		{amount: 0.01, from: "USD", to: "JPY", want: 2}, // 1.5 yen rounds away from zero
+		{amount: 12.34, from: "USD", to: "KWD", want: 3.79},
+		{amount: 100, from: "EUR", to: "KWD", want: 34.122},
+	}
+	for _, tt := range tests {
+		got, ok := book.convert(tt.amount, tt.from, tt.to, "2026-03-01")
+		if !ok || got != tt.want {
+			t.Errorf("convert(%v %s to %s) = %v, %v; want %v", tt.amount, tt.from, tt.to, got, ok, tt.want)
+		}
+	}
+	if _, ok := book.convert(10, "EUR", "GBP", "2026-03-01"); ok {
+		t.Error("converted to a currency with no rate")
+	}
+}
@@ -0,0 +1,691 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"trip-planner/db"
+	"trip-planner/middleware"
+	"trip-planner/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxExactSettle is the most people with an open balance settleBalances finds the fewest
+// payments for. The search is exponential, so larger groups are settled greedily.
+const maxExactSettle = 16
+
+// ListExpenses returns the trip's expenses by date. ?category= and ?currency= narrow the list,
+// and ?from= and ?to= keep expenses dated within that range. Any member may see the expenses.
+func ListExpenses(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleViewer)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := bson.M{"trip_id": trip.ID}
+	if category := query.Get("category"); category != "" {
+		filter["category"] = category
+	}
+	if currency := query.Get("currency"); currency != "" {
+		filter["currency"] = strings.ToUpper(currency)
+	}
+	dates := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		if value := query.Get(param); value != "" {
+			if _, err := time.Parse(models.TripDateLayout, value); err != nil {
+				http.Error(w, param+" must be YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			dates[op] = value
+		}
+	}
+	if len(dates) > 0 {
+		filter["date"] = dates
+	}
+
+	expenses, err := findExpenses(context.Background(), filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch expenses", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(expenses)
+}
+
+// GetExpense returns one of the trip's expenses
+func GetExpense(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	expenseID, err := primitive.ObjectIDFromHex(mux.Vars(r)["expense_id"])
+	if err != nil {
+		http.Error(w, "Invalid expense ID format", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleViewer)
+	if !ok {
+		return
+	}
+
+	var expense models.Expense
+	err = db.ExpenseCollection.FindOne(context.Background(), bson.M{"_id": expenseID, "trip_id": trip.ID}).Decode(&expense)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Expense not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve expense", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(expense)
+}
+
+// CreateExpense records an expense. The payer defaults to the caller, the participants to
+// everyone on the trip and the date to today in the trip's time zone. Owners and editors may
+// record expenses.
+func CreateExpense(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var expense models.Expense
+	if err := json.NewDecoder(r.Body).Decode(&expense); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleEditor)
+	if !ok {
+		return
+	}
+
+	if expense.Category == "" {
+		expense.Category = models.ExpenseCategoryOther
+	}
+	if expense.Currency == "" {
+		expense.Currency = trip.Currency
+	}
+	if expense.PaidBy.IsZero() {
+		expense.PaidBy = principal.UserID
+	}
+	if len(expense.Participants) == 0 {
+		expense.Participants = append([]primitive.ObjectID{trip.UserID}, memberIDs(trip)...)
+	}
+	if expense.Date == "" {
+		expense.Date = tripToday(trip)
+	}
+	if err := validateExpense(trip, &expense); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expense.ID = primitive.NewObjectID()
+	expense.TripID = trip.ID
+	expense.CreatedBy = principal.UserID
+	expense.CreatedAt = time.Now()
+	if _, err := db.ExpenseCollection.InsertOne(context.Background(), expense); err != nil {
+		http.Error(w, "Failed to create expense", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(expense)
+}
+
+// UpdateExpense changes the fields given
+func UpdateExpense(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	expenseID, err := primitive.ObjectIDFromHex(mux.Vars(r)["expense_id"])
+	if err != nil {
+		http.Error(w, "Invalid expense ID format", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Description  *string               `json:"description"`
+		Amount       *float64              `json:"amount"`
+		Currency     *string               `json:"currency"`
+		Category     *string               `json:"category"`
+		PaidBy       *primitive.ObjectID   `json:"paid_by"`
+		Participants *[]primitive.ObjectID `json:"participants"`
+		Date         *string               `json:"date"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleEditor)
+	if !ok {
+		return
+	}
+
+	var expense models.Expense
+	err = db.ExpenseCollection.FindOne(context.Background(), bson.M{"_id": expenseID, "trip_id": trip.ID}).Decode(&expense)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Expense not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve expense", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Apply the changes to a copy and validate the result as a whole
+	if body.Description != nil {
+		expense.Description = *body.Description
+	}
+	if body.Amount != nil {
+		expense.Amount = *body.Amount
+	}
+	if body.Currency != nil {
+		expense.Currency = *body.Currency
+	}
+	if body.Category != nil {
+		expense.Category = *body.Category
+	}
+	if body.PaidBy != nil {
+		expense.PaidBy = *body.PaidBy
+	}
+	if body.Participants != nil {
+		expense.Participants = *body.Participants
+	}
+	if body.Date != nil {
+		expense.Date = *body.Date
+	}
+	if err := validateExpense(trip, &expense); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.ExpenseCollection.ReplaceOne(context.Background(), bson.M{"_id": expense.ID, "trip_id": trip.ID}, expense)
+	if err != nil {
+		http.Error(w, "Failed to update expense", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(expense)
+}
+
+// DeleteExpense removes an expense
+func DeleteExpense(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	expenseID, err := primitive.ObjectIDFromHex(mux.Vars(r)["expense_id"])
+	if err != nil {
+		http.Error(w, "Invalid expense ID format", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleEditor)
+	if !ok {
+		return
+	}
+
+	result, err := db.ExpenseCollection.DeleteOne(context.Background(), bson.M{"_id": expenseID, "trip_id": trip.ID})
+	if err != nil {
+		http.Error(w, "Failed to delete expense", http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		http.Error(w, "Expense not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetTripBudget reports what was spent on each category against the trip's budget
+func GetTripBudget(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleViewer)
+	if !ok {
+		return
+	}
+
+	expenses, err := findExpenses(context.Background(), bson.M{"trip_id": trip.ID})
+	if err != nil {
+		http.Error(w, "Failed to fetch expenses", http.StatusInternalServerError)
+		return
+	}
+	rates, err := loadExpenseRates(context.Background(), trip, expenses)
+	if err != nil {
+		http.Error(w, "Failed to fetch exchange rates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(budgetReport(trip, expenses, rates))
+}
+
+// SetTripBudget replaces the trip's currency and budget targets, at most one per category.
+// An empty list of targets removes the budget. Owners and editors may set the budget.
+func SetTripBudget(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		Currency string                `json:"currency"`
+		Targets  []models.BudgetTarget `json:"targets"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	body.Currency = strings.ToUpper(strings.TrimSpace(body.Currency))
+	if !models.ValidCurrencyCode(body.Currency) {
+		http.Error(w, "Currency must be an ISO 4217 currency code", http.StatusBadRequest)
+		return
+	}
+	seen := map[string]bool{}
+	for i, target := range body.Targets {
+		if !models.ValidExpenseCategory(target.Category) {
+			http.Error(w, "Category must be one of lodging, transport, food, activities, shopping, other", http.StatusBadRequest)
+			return
+		}
+		if seen[target.Category] {
+			http.Error(w, "Each category can have only one target", http.StatusBadRequest)
+			return
+		}
+		seen[target.Category] = true
+		if math.IsNaN(target.Amount) || math.IsInf(target.Amount, 0) || target.Amount < 0 {
+			http.Error(w, "Budget amounts can't be negative", http.StatusBadRequest)
+			return
+		}
+		body.Targets[i].Amount = models.RoundAmount(target.Amount, body.Currency)
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleEditor)
+	if !ok {
+		return
+	}
+
+	update := bson.M{"$set": bson.M{"currency": body.Currency, "budget": body.Targets}}
+	if len(body.Targets) == 0 {
+		update = bson.M{"$set": bson.M{"currency": body.Currency}, "$unset": bson.M{"budget": ""}}
+	}
+	if _, err := db.TripCollection.UpdateOne(context.Background(), bson.M{"_id": trip.ID}, update); err != nil {
+		http.Error(w, "Failed to update budget", http.StatusInternalServerError)
+		return
+	}
+	trip.Currency, trip.Budget = body.Currency, body.Targets
+
+	expenses, err := findExpenses(context.Background(), bson.M{"trip_id": trip.ID})
+	if err != nil {
+		http.Error(w, "Failed to fetch expenses", http.StatusInternalServerError)
+		return
+	}
+	rates, err := loadExpenseRates(context.Background(), trip, expenses)
+	if err != nil {
+		http.Error(w, "Failed to fetch exchange rates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(budgetReport(trip, expenses, rates))
+}
+
+// SettleTrip works out each member's balance and the fewest payments that even out who paid
+// what. Expenses are settled in the trip's currency, converted at the rate in effect on their
+// date; those in a currency with no known rate are settled separately in that currency.
+func SettleTrip(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleViewer)
+	if !ok {
+		return
+	}
+
+	expenses, err := findExpenses(context.Background(), bson.M{"trip_id": trip.ID})
+	if err != nil {
+		http.Error(w, "Failed to fetch expenses", http.StatusInternalServerError)
+		return
+	}
+	rates, err := loadExpenseRates(context.Background(), trip, expenses)
+	if err != nil {
+		http.Error(w, "Failed to fetch exchange rates", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settleExpenses(trip.Currency, expenses, rates))
+}
+
+// validateExpense normalises an expense and checks it, including that the payer and every
+// participant are on the trip
+func validateExpense(trip *models.Trip, expense *models.Expense) error {
+	expense.Description = strings.TrimSpace(expense.Description)
+	expense.Currency = strings.ToUpper(strings.TrimSpace(expense.Currency))
+	if !models.ValidCurrencyCode(expense.Currency) {
+		return errors.New("Currency must be an ISO 4217 currency code")
+	}
+	if math.IsNaN(expense.Amount) || math.IsInf(expense.Amount, 0) || models.ToMinor(expense.Amount, expense.Currency) <= 0 {
+		return errors.New("Amount must be greater than zero")
+	}
+	expense.Amount = models.RoundAmount(expense.Amount, expense.Currency)
+	if !models.ValidExpenseCategory(expense.Category) {
+		return errors.New("Category must be one of lodging, transport, food, activities, shopping, other")
+	}
+	if _, err := time.Parse(models.TripDateLayout, expense.Date); err != nil {
+		return errors.New("date must be YYYY-MM-DD")
+	}
+	if trip.MemberRole(expense.PaidBy) == "" {
+		return errors.New("The payer must be a member of the trip")
+	}
+
+	participants := []primitive.ObjectID{}
+	seen := map[primitive.ObjectID]bool{}
+	for _, userID := range expense.Participants {
+		if seen[userID] {
+			continue
+		}
+		if trip.MemberRole(userID) == "" {
+			return errors.New("Every participant must be a member of the trip")
+		}
+		seen[userID] = true
+		participants = append(participants, userID)
+	}
+	if len(participants) == 0 {
+		return errors.New("An expense needs at least one participant")
+	}
+	expense.Participants = participants
+	return nil
+}
+
+// budgetReport totals the expenses by category in the trip's currency and sets them against
+// its targets. Without a trip currency nothing is converted and each currency is totalled apart.
+func budgetReport(trip *models.Trip, expenses []models.Expense, rates *rateBook) models.BudgetReport {
+	spent := map[categoryKey]int64{}
+	unconverted := map[categoryKey]int64{}
+	for _, expense := range expenses {
+		amount, currency := expense.Amount, expense.Currency
+		if trip.Currency != "" {
+			converted, ok := rates.convert(amount, currency, trip.Currency, expense.Date)
+			if !ok {
+				unconverted[categoryKey{expense.Category, currency}] += models.ToMinor(amount, currency)
+				continue
+			}
+			amount, currency = converted, trip.Currency
+		}
+		spent[categoryKey{expense.Category, currency}] += models.ToMinor(amount, currency)
+	}
+
+	report := models.BudgetReport{Currency: trip.Currency, Targets: trip.Budget}
+	if report.Targets == nil {
+		report.Targets = []models.BudgetTarget{}
+	}
+	var totalBudget int64
+	for _, target := range trip.Budget {
+		totalBudget += models.ToMinor(target.Amount, trip.Currency)
+		if _, ok := spent[categoryKey{target.Category, trip.Currency}]; !ok {
+			spent[categoryKey{target.Category, trip.Currency}] = 0
+		}
+	}
+	report.Categories = categoryTotals(spent)
+	for i, total := range report.Categories {
+		if total.Currency != trip.Currency {
+			continue
+		}
+		for _, target := range trip.Budget {
+			if target.Category == total.Category {
+				budget := target.Amount
+				remaining := models.RoundAmount(target.Amount-total.Spent, trip.Currency)
+				report.Categories[i].Budget, report.Categories[i].Remaining = &budget, &remaining
+			}
+		}
+		report.TotalSpent += total.Spent
+	}
+	if len(unconverted) > 0 {
+		report.Unconverted = categoryTotals(unconverted)
+	}
+	report.TotalBudget = models.FromMinor(totalBudget, trip.Currency)
+	report.TotalSpent = models.RoundAmount(report.TotalSpent, trip.Currency)
+	return report
+}
+
+// categoryKey identifies a spending total by category and currency
+type categoryKey struct{ category, currency string }
+
+// categoryTotals turns totals in minor units into a list ordered by category, then currency
+func categoryTotals(spent map[categoryKey]int64) []models.CategoryTotal {
+	totals := make([]models.CategoryTotal, 0, len(spent))
+	for k, units := range spent {
+		totals = append(totals, models.CategoryTotal{Category: k.category, Currency: k.currency, Spent: models.FromMinor(units, k.currency)})
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Category != totals[j].Category {
+			return totals[i].Category < totals[j].Category
+		}
+		return totals[i].Currency < totals[j].Currency
+	})
+	return totals
+}
+
+// settleExpenses works out every member's balance and the transfers that settle them, in the
+// base currency where the expense can be converted and in its own currency otherwise
+func settleExpenses(base string, expenses []models.Expense, rates *rateBook) models.Settlement {
+	type key struct {
+		userID   primitive.ObjectID
+		currency string
+	}
+	paid := map[key]int64{}
+	owed := map[key]int64{}
+	for _, expense := range expenses {
+		if base != "" {
+			if converted, ok := rates.convert(expense.Amount, expense.Currency, base, expense.Date); ok {
+				expense.Amount, expense.Currency = converted, base
+			}
+		}
+		paid[key{expense.PaidBy, expense.Currency}] += models.ToMinor(expense.Amount, expense.Currency)
+		for i, share := range expense.Shares() {
+			owed[key{expense.Participants[i], expense.Currency}] += share
+		}
+	}
+
+	settlement := models.Settlement{Balances: []models.MemberBalance{}, Transfers: []models.Transfer{}}
+	nets := map[string]map[primitive.ObjectID]int64{}
+	for _, totals := range []map[key]int64{paid, owed} {
+		for k := range totals {
+			if nets[k.currency] == nil {
+				nets[k.currency] = map[primitive.ObjectID]int64{}
+			}
+			nets[k.currency][k.userID] = paid[k] - owed[k]
+		}
+	}
+
+	currencies := make([]string, 0, len(nets))
+	for currency := range nets {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		userIDs := sortedUserIDs(nets[currency])
+		for _, userID := range userIDs {
+			k := key{userID, currency}
+			settlement.Balances = append(settlement.Balances, models.MemberBalance{
+				UserID:   userID,
+				Currency: currency,
+				Paid:     models.FromMinor(paid[k], currency),
+				Owed:     models.FromMinor(owed[k], currency),
+				Net:      models.FromMinor(paid[k]-owed[k], currency),
+			})
+		}
+		settlement.Transfers = append(settlement.Transfers, settleBalances(nets[currency], currency)...)
+	}
+	return settlement
+}
+
+// settleBalances finds the fewest payments that clear everyone's balance. Balances are in
+// minor units of the currency and sum to zero. A group of n people whose balances sum to zero
+// can always be settled in n-1 payments, so the fewest payments come from splitting everyone
+// into as many such groups as possible and settling each on its own.
+func settleBalances(balances map[primitive.ObjectID]int64, currency string) []models.Transfer {
+	var people []primitive.ObjectID
+	for _, userID := range sortedUserIDs(balances) {
+		if balances[userID] != 0 {
+			people = append(people, userID)
+		}
+	}
+	if len(people) == 0 {
+		return nil
+	}
+	if len(people) > maxExactSettle {
+		return settleGroup(people, balances, currency)
+	}
+
+	// groups[mask] is the most zero-sum groups the people in mask can be split into, found
+	// by taking them out one at a time and counting each point where the rest sums to zero
+	full := 1<<len(people) - 1
+	sums := make([]int64, full+1)
+	groups := make([]int, full+1)
+	for mask := 1; mask <= full; mask++ {
+		for i := range people {
+			if mask&(1<<i) == 0 {
+				continue
+			}
+			rest := mask &^ (1 << i)
+			sums[mask] = sums[rest] + balances[people[i]]
+			if groups[rest] > groups[mask] {
+				groups[mask] = groups[rest]
+			}
+		}
+		if sums[mask] == 0 {
+			groups[mask]++
+		}
+	}
+
+	// Walk back down the best order, closing a group each time the remainder sums to zero
+	var transfers []models.Transfer
+	var group []primitive.ObjectID
+	for mask := full; mask != 0; {
+		best := -1
+		for i := range people {
+			if mask&(1<<i) != 0 && (best < 0 || groups[mask&^(1<<i)] > groups[mask&^(1<<best)]) {
+				best = i
+			}
+		}
+		group = append(group, people[best])
+		mask &^= 1 << best
+		if sums[mask] == 0 {
+			transfers = append(transfers, settleGroup(group, balances, currency)...)
+			group = nil
+		}
+	}
+	return transfers
+}
+
+// settleGroup settles people whose balances sum to zero by repeatedly having the largest
+// debtor pay the largest creditor. Each payment clears at least one of them, so the group
+// needs at most one payment fewer than it has people.
+func settleGroup(people []primitive.ObjectID, balances map[primitive.ObjectID]int64, currency string) []models.Transfer {
+	remaining := make(map[primitive.ObjectID]int64, len(people))
+	for _, userID := range people {
+		remaining[userID] = balances[userID]
+	}
+
+	var transfers []models.Transfer
+	for {
+		var debtor, creditor primitive.ObjectID
+		var debt, credit int64
+		for _, userID := range people {
+			if balance := remaining[userID]; balance < debt {
+				debtor, debt = userID, balance
+			} else if balance > credit {
+				creditor, credit = userID, balance
+			}
+		}
+		if debt == 0 || credit == 0 {
+			return transfers
+		}
+		amount := -debt
+		if credit < amount {
+			amount = credit
+		}
+		remaining[debtor] += amount
+		remaining[creditor] -= amount
+		transfers = append(transfers, models.Transfer{
+			From:     debtor,
+			To:       creditor,
+			Amount:   models.FromMinor(amount, currency),
+			Currency: currency,
+		})
+	}
+}
+
+// loadExpenseRates fetches the exchange rates needed to convert the expenses to the trip's currency
+func loadExpenseRates(ctx context.Context, trip *models.Trip, expenses []models.Expense) (*rateBook, error) {
+	if trip.Currency == "" {
+		return &rateBook{}, nil
+	}
+	currencies := []string{trip.Currency}
+	seen := map[string]bool{trip.Currency: true}
+	for _, expense := range expenses {
+		if !seen[expense.Currency] {
+			seen[expense.Currency] = true
+			currencies = append(currencies, expense.Currency)
+		}
+	}
+	if len(currencies) == 1 {
+		return &rateBook{}, nil
+	}
+	return loadRateBook(ctx, currencies)
+}
+
+// findExpenses returns the expenses matching filter by date, then by when they were recorded
+func findExpenses(ctx context.Context, filter bson.M) ([]models.Expense, error) {
+	cursor, err := db.ExpenseCollection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "date", Value: 1}, {Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	expenses := []models.Expense{}
+	if err := cursor.All(ctx, &expenses); err != nil {
+		return nil, err
+	}
+	return expenses, nil
+}
+
+// memberIDs returns the IDs of the trip's members other than its creator
+func memberIDs(trip *models.Trip) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(trip.Members))
+	for _, member := range trip.Members {
+		ids = append(ids, member.UserID)
+	}
+	return ids
+}
+
+// tripToday returns today's date in the trip's time zone, or in UTC when it has none
+func tripToday(trip *models.Trip) string {
+	location := time.UTC
+	if trip.TimeZone != "" {
+		if loaded, err := time.LoadLocation(trip.TimeZone); err == nil {
+			location = loaded
+		}
+	}
+	return time.Now().In(location).Format(models.TripDateLayout)
+}
+
+// sortedUserIDs returns the keys of balances in a stable order
+func sortedUserIDs(balances map[primitive.ObjectID]int64) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(balances))
+	for userID := range balances {
+		ids = append(ids, userID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Hex() < ids[j].Hex() })
+	return ids
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"testing"
+	"trip-planner/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// testUsers returns n user IDs that sort in the order they are returned
+func testUsers(n int) []primitive.ObjectID {
+	users := make([]primitive.ObjectID, n)
+	for i := range users {
+		users[i][11] = byte(i + 1)
+	}
+	return users
+}
+
+// applyTransfers returns the balances left after making the payments, in minor units
+func applyTransfers(t *testing.T, balances map[primitive.ObjectID]int64, transfers []models.Transfer, currency string) map[primitive.ObjectID]int64 {
+	t.Helper()
+	left := make(map[primitive.ObjectID]int64, len(balances))
+	for userID, balance := range balances {
+		left[userID] = balance
+	}
+	for _, transfer := range transfers {
+		amount := models.ToMinor(transfer.Amount, currency)
+		if amount <= 0 {
+			t.Errorf("transfer of %v from %s to %s", transfer.Amount, transfer.From.Hex(), transfer.To.Hex())
+		}
+		if transfer.Currency != currency {
+			t.Errorf("transfer currency = %q, want %q", transfer.Currency, currency)
+		}
+		left[transfer.From] += amount
+		left[transfer.To] -= amount
+	}
+	return left
+}
+
+func TestSettleBalances(t *testing.T) {
+	tests := []struct {
+		name          string
+		balances      []int64 // by user, summing to zero
+		wantTransfers int
+		greedy        int // payments settleGroup alone needs, when it differs
+	}{
+		{name: "nobody owes anything", balances: []int64{0, 0}, wantTransfers: 0},
+		{name: "one debt", balances: []int64{500, -500}, wantTransfers: 1},
+		{name: "one payer, three owe", balances: []int64{900, -300, -300, -300}, wantTransfers: 3},
+		// Largest debtor pays largest creditor would take 4 payments here; splitting into
+		// {+5, -3, -2} and {+4, -4} takes 3
+		{name: "two groups where greedy needs an extra payment", balances: []int64{500, 400, -400, -300, -200}, wantTransfers: 3, greedy: 4},
+		{name: "three pairs", balances: []int64{100, 250, 700, -700, -250, -100}, wantTransfers: 3},
+		{name: "pair and triple", balances: []int64{1000, -1000, 300, 200, -500}, wantTransfers: 3},
+		{name: "no zero-sum subset", balances: []int64{600, 400, -500, -500}, wantTransfers: 3},
+		{name: "zero balances are left out", balances: []int64{0, 300, 0, -300}, wantTransfers: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := testUsers(len(tt.balances))
+			balances := map[primitive.ObjectID]int64{}
+			for i, balance := range tt.balances {
+				balances[users[i]] = balance
+			}
+
+			transfers := settleBalances(balances, "EUR")
+			if len(transfers) != tt.wantTransfers {
+				t.Errorf("got %d transfers, want %d: %+v", len(transfers), tt.wantTransfers, transfers)
+			}
+			if tt.greedy != 0 {
+				if got := len(settleGroup(users, balances, "EUR")); got != tt.greedy {
+					t.Errorf("settleGroup alone took %d transfers, want %d", got, tt.greedy)
+				}
+			}
+			for userID, left := range applyTransfers(t, balances, transfers, "EUR") {
+				if left != 0 {
+					t.Errorf("user %s is left with %d", userID.Hex(), left)
+				}
+			}
+		})
+	}
+}
+
+func TestSettleBalancesMinorUnits(t *testing.T) {
+	users := testUsers(3)
+	balances := map[primitive.ObjectID]int64{users[0]: 1001, users[1]: -500, users[2]: -501}
+
+	transfers := settleBalances(balances, "KWD")
+	if len(transfers) != 2 {
+		t.Fatalf("got %d transfers, want 2", len(transfers))
+	}
+	for _, transfer := range transfers {
+		if transfer.Amount != 0.5 && transfer.Amount != 0.501 {
+			t.Errorf("transfer amount = %v, want 0.5 or 0.501 KWD", transfer.Amount)
+		}
+	}
+}
+
+func TestSettleBalancesFallsBackAboveLimit(t *testing.T) {
+	// Pairs of +n/-n are settled one payment each by the exact search; past the limit the
+	// largest debtor still pays the largest creditor, which finds the same pairs
+	count := maxExactSettle + 2
+	users := testUsers(count)
+	balances := map[primitive.ObjectID]int64{}
+	for i := 0; i < count; i += 2 {
+		balances[users[i]] = int64(100 * (i + 1))
+		balances[users[i+1]] = -int64(100 * (i + 1))
+	}
+
+	transfers := settleBalances(balances, "USD")
+	if len(transfers) != count/2 {
+		t.Errorf("got %d transfers, want %d", len(transfers), count/2)
+	}
+	for userID, left := range applyTransfers(t, balances, transfers, "USD") {
+		if left != 0 {
+			t.Errorf("user %s is left with %d", userID.Hex(), left)
+		}
+	}
+}
+
+func TestSettleGroup(t *testing.T) {
+	tests := []struct {
+		name     string
+		balances []int64
+	}{
+		{name: "two", balances: []int64{-250, 250}},
+		{name: "one creditor", balances: []int64{-100, -200, -300, 600}},
+		{name: "one debtor", balances: []int64{-600, 100, 200, 300}},
+		{name: "mixed", balances: []int64{700, -450, 150, -300, -100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := testUsers(len(tt.balances))
+			balances := map[primitive.ObjectID]int64{}
+			for i, balance := range tt.balances {
+				balances[users[i]] = balance
+			}
+
+			transfers := settleGroup(users, balances, "USD")
+			if len(transfers) > len(users)-1 {
+				t.Errorf("got %d transfers for %d people", len(transfers), len(users))
+			}
+			for userID, left := range applyTransfers(t, balances, transfers, "USD") {
+				if left != 0 {
+					t.Errorf("user %s is left with %d", userID.Hex(), left)
+				}
+			}
+		})
+	}
+}
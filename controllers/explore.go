@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"trip-planner/db"
+	"trip-planner/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Page sizes for the explore feed
+const (
+	defaultExploreLimit = 20
+	maxExploreLimit     = 100
+)
+
+// ExploreTrips lists public trips, newest first, for anyone. ?category= and ?region= filter
+// case-insensitively, ?limit= and ?before= (a trip ID) page through the feed.
+func ExploreTrips(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := bson.M{"visibility": models.TripVisibilityPublic}
+	if value := query.Get("category"); value != "" {
+		filter["category"] = value
+	}
+	if value := query.Get("region"); value != "" {
+		filter["region"] = value
+	}
+
+	limit := defaultExploreLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxExploreLimit {
+			http.Error(w, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("before"); value != "" {
+		before, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			http.Error(w, "Invalid before ID format", http.StatusBadRequest)
+			return
+		}
+		filter["_id"] = bson.M{"$lt": before}
+	}
+
+	cursor, err := db.TripCollection.Find(context.Background(), filter,
+		options.Find().
+			SetSort(bson.D{{Key: "_id", Value: -1}}).
+			SetLimit(int64(limit)).
+			SetCollation(db.CaseInsensitive),
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch trips", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	trips := []models.Trip{}
+	for cursor.Next(context.Background()) {
+		var trip models.Trip
+		if err := cursor.Decode(&trip); err != nil {
+			http.Error(w, "Error decoding trip", http.StatusInternalServerError)
+			return
+		}
+		trips = append(trips, trip.ReadOnlyView())
+	}
+	if err := cursor.Err(); err != nil {
+		http.Error(w, "Error while fetching trips", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trips)
+}
+
+// GetExploreTrip returns the read-only view of a public or unlisted trip to anyone with its ID
+func GetExploreTrip(w http.ResponseWriter, r *http.Request) {
+	tripID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid trip ID format", http.StatusBadRequest)
+		return
+	}
+
+	var trip models.Trip
+	err = db.TripCollection.FindOne(context.Background(), bson.M{
+		"_id":        tripID,
+		"visibility": bson.M{"$in": bson.A{models.TripVisibilityPublic, models.TripVisibilityUnlisted}},
+	}).Decode(&trip)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Trip not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve trip", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(trip.ReadOnlyView())
+}
@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"trip-planner/db"
+	"trip-planner/middleware"
+	"trip-planner/models"
+	"trip-planner/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Invite lifetimes; the owner may pick anything up to the maximum
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	maxInviteTTL     = 30 * 24 * time.Hour
+)
+
+var errInviteUnavailable = errors.New("invite is no longer valid")
+
+// CreateTripInvite mints an invite link for the trip. Only owners may invite. The body may set
+// the role granted (editor or viewer, default viewer), max_uses (default 1, 0 for unlimited)
+// and expires_in_hours (default 7 days, at most 30).
+func CreateTripInvite(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		Role           string `json:"role"`
+		MaxUses        *int   `json:"max_uses"`
+		ExpiresInHours int    `json:"expires_in_hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if body.Role == "" {
+		body.Role = models.TripRoleViewer
+	}
+	if body.Role != models.TripRoleEditor && body.Role != models.TripRoleViewer {
+		http.Error(w, "Role must be editor or viewer", http.StatusBadRequest)
+		return
+	}
+	maxUses := 1
+	if body.MaxUses != nil {
+		maxUses = *body.MaxUses
+	}
+	if maxUses < 0 {
+		http.Error(w, "max_uses can't be negative", http.StatusBadRequest)
+		return
+	}
+	ttl := defaultInviteTTL
+	if body.ExpiresInHours != 0 {
+		ttl = time.Duration(body.ExpiresInHours) * time.Hour
+	}
+	if ttl <= 0 || ttl > maxInviteTTL {
+		http.Error(w, "expires_in_hours must be between 1 and 720", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleOwner)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	invite := models.TripInvite{
+		ID:         primitive.NewObjectID(),
+		TripID:     trip.ID,
+		CreatedBy:  principal.UserID,
+		Role:       body.Role,
+		MaxUses:    maxUses,
+		AcceptedBy: []primitive.ObjectID{},
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	token, err := utils.GenerateInviteToken(invite.ID.Hex(), ttl)
+	if err != nil {
+		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		return
+	}
+	invite.TokenHash = utils.HashToken(token)
+	if _, err := db.TripInviteCollection.InsertOne(context.Background(), invite); err != nil {
+		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "trip.invite_create",
+		TargetType: "trip",
+		TargetID:   trip.ID,
+		Details:    "invite " + invite.ID.Hex() + " as " + invite.Role,
+	})
+
+	// The token is only ever shown here
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		models.TripInvite
+		Token string `json:"token"`
+		URL   string `json:"url"`
+	}{invite, token, appLink("/invites/accept", token)})
+}
+
+// ListTripInvites returns the trip's invites that can still be accepted. Only owners may list them.
+func ListTripInvites(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleOwner)
+	if !ok {
+		return
+	}
+
+	cursor, err := db.TripInviteCollection.Find(context.Background(),
+		bson.M{
+			"trip_id":    trip.ID,
+			"revoked_at": bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": time.Now()},
+			// Used-up invites can't be accepted any more
+			"$or": bson.A{
+				bson.M{"max_uses": 0},
+				bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$max_uses"}}},
+			},
+		},
+		options.Find().SetSort(bson.M{"created_at": -1}),
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch invites", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	invites := []models.TripInvite{}
+	if err := cursor.All(context.Background(), &invites); err != nil {
+		http.Error(w, "Error while fetching invites", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invites)
+}
+
+// RevokeTripInvite stops an invite from being accepted. Members who already joined stay.
+func RevokeTripInvite(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	inviteID, err := primitive.ObjectIDFromHex(mux.Vars(r)["invite_id"])
+	if err != nil {
+		http.Error(w, "Invalid invite ID format", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleOwner)
+	if !ok {
+		return
+	}
+
+	result, err := db.TripInviteCollection.UpdateOne(context.Background(),
+		bson.M{"_id": inviteID, "trip_id": trip.ID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		http.Error(w, "Failed to revoke invite", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Invite not found", http.StatusNotFound)
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "trip.invite_revoke",
+		TargetType: "trip",
+		TargetID:   trip.ID,
+		Details:    "invite " + inviteID.Hex(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AcceptTripInvite adds the caller to the invite's trip. Accepting again, or accepting an invite
+// to a trip the caller already belongs to, succeeds without using up the invite.
+func AcceptTripInvite(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		http.Error(w, "Token must be provided", http.StatusBadRequest)
+		return
+	}
+
+	// The signature and expiry are checked before the database is touched
+	claims, err := utils.ValidateInviteToken(body.Token)
+	if err != nil {
+		http.Error(w, "Invalid or expired invite", http.StatusBadRequest)
+		return
+	}
+	inviteID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		http.Error(w, "Invalid or expired invite", http.StatusBadRequest)
+		return
+	}
+
+	var invite models.TripInvite
+	err = db.TripInviteCollection.FindOne(context.Background(), bson.M{
+		"_id":        inviteID,
+		"token_hash": utils.HashToken(body.Token),
+	}).Decode(&invite)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Invalid or expired invite", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to accept invite", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var trip models.Trip
+	err = db.TripCollection.FindOne(context.Background(), bson.M{"_id": invite.TripID}).Decode(&trip)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Trip not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to accept invite", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Already a member: nothing to do, whatever the invite's state
+	if role := trip.MemberRole(principal.UserID); role != "" {
+		writeInviteAcceptance(w, trip.ID, role)
+		return
+	}
+
+	err = acceptInvite(context.Background(), &invite, principal.UserID)
+	if err != nil {
+		if err == errInviteUnavailable {
+			http.Error(w, "Invalid or expired invite", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to accept invite", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "trip.invite_accept",
+		TargetType: "trip",
+		TargetID:   trip.ID,
+		Details:    "invite " + invite.ID.Hex() + " as " + invite.Role,
+	})
+
+	writeInviteAcceptance(w, trip.ID, invite.Role)
+}
+
+// acceptInvite uses up one acceptance of the invite and adds the user to the trip, in one
+// transaction so a use is never spent without the membership being granted
+func acceptInvite(ctx context.Context, invite *models.TripInvite, userID primitive.ObjectID) error {
+	session, err := db.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		filter := bson.M{
+			"_id":         invite.ID,
+			"revoked_at":  bson.M{"$exists": false},
+			"expires_at":  bson.M{"$gt": time.Now()},
+			"accepted_by": bson.M{"$ne": userID},
+		}
+		if invite.MaxUses > 0 {
+			filter["uses"] = bson.M{"$lt": invite.MaxUses}
+		}
+		result, err := db.TripInviteCollection.UpdateOne(sc, filter, bson.M{
+			"$inc":  bson.M{"uses": 1},
+			"$push": bson.M{"accepted_by": userID},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, errInviteUnavailable
+		}
+
+		member := models.TripMember{UserID: userID, Role: invite.Role, AddedAt: time.Now()}
+		result, err = db.TripCollection.UpdateOne(sc,
+			bson.M{"_id": invite.TripID, "user_id": bson.M{"$ne": userID}, "members.user_id": bson.M{"$ne": userID}},
+			bson.M{"$push": bson.M{"members": member}},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 {
+			return nil, errInviteUnavailable
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// writeInviteAcceptance answers an accepted invite with the trip joined and the role held
+func writeInviteAcceptance(w http.ResponseWriter, tripID primitive.ObjectID, role string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"trip_id": tripID.Hex(),
+		"role":    role,
+	})
+}
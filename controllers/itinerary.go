@@ -0,0 +1,747 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"trip-planner/db"
+	"trip-planner/middleware"
+	"trip-planner/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errReorderMismatch = errors.New("the new order must list every item exactly once")
+
+// ListTripDays returns the trip's days in order, each with its stops in order
+func ListTripDays(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	trip, ok := loadReadableTrip(w, r, principal)
+	if !ok {
+		return
+	}
+
+	days := []models.TripDay{}
+	cursor, err := db.TripDayCollection.Find(context.Background(), bson.M{"trip_id": trip.ID},
+		options.Find().SetSort(bson.D{{Key: "position", Value: 1}}))
+	if err == nil {
+		err = cursor.All(context.Background(), &days)
+	}
+	if err != nil {
+		http.Error(w, "Failed to fetch itinerary", http.StatusInternalServerError)
+		return
+	}
+
+	stops, err := findStops(context.Background(), bson.M{"trip_id": trip.ID, "day_id": bson.M{"$ne": primitive.NilObjectID}})
+	if err != nil {
+		http.Error(w, "Failed to fetch itinerary", http.StatusInternalServerError)
+		return
+	}
+	byDay := map[primitive.ObjectID][]models.Stop{}
+	for _, stop := range stops {
+		byDay[stop.DayID] = append(byDay[stop.DayID], stop)
+	}
+	for i := range days {
+		days[i].Stops = byDay[days[i].ID]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(days)
+}
+
+// CreateTripDay appends a day to the itinerary. Owners and editors may change the itinerary.
+func CreateTripDay(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var day models.TripDay
+	if err := json.NewDecoder(r.Body).Decode(&day); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	day.Title = strings.TrimSpace(day.Title)
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleEditor)
+	if !ok {
+		return
+	}
+
+	position, err := nextPosition(context.Background(), db.TripDayCollection, bson.M{"trip_id": trip.ID})
+	if err != nil {
+		http.Error(w, "Failed to create day", http.StatusInternalServerError)
+		return
+	}
+
+	day.ID = primitive.NewObjectID()
+	day.TripID = trip.ID
+	day.Position = position
+	day.CreatedAt = time.Now()
+	day.Stops = nil
+	if _, err := db.TripDayCollection.InsertOne(context.Background(), day); err != nil {
+		http.Error(w, "Failed to create day", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(day)
+}
+
+// UpdateTripDay changes a day's title or notes
+func UpdateTripDay(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	dayID, err := primitive.ObjectIDFromHex(mux.Vars(r)["day_id"])
+	if err != nil {
+		http.Error(w, "Invalid day ID format", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Title *string `json:"title"`
+		Notes *string `json:"notes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	updateFields := bson.M{}
+	if body.Title != nil {
+		updateFields["title"] = strings.TrimSpace(*body.Title)
+	}
+	if body.Notes != nil {
+		updateFields["notes"] = *body.Notes
+	}
+	if len(updateFields) == 0 {
+		http.Error(w, "No fields to update", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleEditor)
+	if !ok {
+		return
+	}
+
+	var day models.TripDay
+	err = db.TripDayCollection.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": dayID, "trip_id": trip.ID},
+		bson.M{"$set": updateFields},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&day)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Day not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to update day", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(day)
+}
+
+// DeleteTripDay removes a day from the itinerary. Its stops aren't lost, they move to the end
+// of the unscheduled stops.
+func DeleteTripDay(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	dayID, err := primitive.ObjectIDFromHex(mux.Vars(r)["day_id"])
+	if err != nil {
+		http.Error(w, "Invalid day ID format", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleEditor)
+	if !ok {
+		return
+	}
+
+	session, err := db.Client.StartSession()
+	if err != nil {
+		http.Error(w, "Failed to delete day", http.StatusInternalServerError)
+		return
+	}
+	defer session.EndSession(context.Background())
+
+	_, err = session.WithTransaction(context.Background(), func(sc mongo.SessionContext) (interface{}, error) {
+		result, err := db.TripDayCollection.DeleteOne(sc, bson.M{"_id": dayID, "trip_id": trip.ID})
+		if err != nil {
+			return nil, err
+		}
+		if result.DeletedCount == 0 {
+			return nil, mongo.ErrNoDocuments
+		}
+
+		offset, err := nextPosition(sc, db.StopCollection, bson.M{"trip_id": trip.ID, "day_id": primitive.NilObjectID})
+		if err != nil {
+			return nil, err
+		}
+		_, err = db.StopCollection.UpdateMany(sc,
+			bson.M{"trip_id": trip.ID, "day_id": dayID},
+			mongo.Pipeline{{{Key: "$set", Value: bson.M{
+				"day_id":   primitive.NilObjectID,
+				"position": bson.M{"$add": bson.A{"$position", offset}},
+			}}}},
+		)
+		return nil, err
+	})
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Day not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to delete day", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReorderTripDays puts the trip's days in the order of day_ids, which must list every day once
+func ReorderTripDays(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		DayIDs []primitive.ObjectID `json:"day_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleEditor)
+	if !ok {
+		return
+	}
+
+	err := reorder(context.Background(), db.TripDayCollection, bson.M{"trip_id": trip.ID}, body.DayIDs)
+	if err != nil {
+		if err == errReorderMismatch {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to reorder days", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListStops returns the trip's stops ordered by day and position. ?day_id= limits them to one
+// day, ?day_id=none to the unscheduled stops.
+func ListStops(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	trip, ok := loadReadableTrip(w, r, principal)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"trip_id": trip.ID}
+	switch value := r.URL.Query().Get("day_id"); value {
+	case "":
+	case "none":
+		filter["day_id"] = primitive.NilObjectID
+	default:
+		dayID, err := primitive.ObjectIDFromHex(value)
+		if err != nil {
+			http.Error(w, "Invalid day ID format", http.StatusBadRequest)
+			return
+		}
+		filter["day_id"] = dayID
+	}
+
+	stops, err := findStops(context.Background(), filter)
+	if err != nil {
+		http.Error(w, "Failed to fetch stops", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stops)
+}
+
+// GetStop returns a single stop
+func GetStop(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	stopID, err := primitive.ObjectIDFromHex(mux.Vars(r)["stop_id"])
+	if err != nil {
+		http.Error(w, "Invalid stop ID format", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadReadableTrip(w, r, principal)
+	if !ok {
+		return
+	}
+
+	var stop models.Stop
+	err = db.StopCollection.FindOne(context.Background(), bson.M{"_id": stopID, "trip_id": trip.ID}).Decode(&stop)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Stop not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve stop", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stop)
+}
+
+// CreateStop adds a stop at the end of a day, or to the unscheduled stops when day_id is left out
+func CreateStop(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var stop models.Stop
+	if err := json.NewDecoder(r.Body).Decode(&stop); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if stop.Type == "" {
+		stop.Type = models.StopTypeOther
+	}
+	if err := validateStop(&stop); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleEditor)
+	if !ok {
+		return
+	}
+	if !dayOnTrip(w, trip.ID, stop.DayID) {
+		return
+	}
+
+	position, err := nextPosition(context.Background(), db.StopCollection, bson.M{"trip_id": trip.ID, "day_id": stop.DayID})
+	if err != nil {
+		http.Error(w, "Failed to create stop", http.StatusInternalServerError)
+		return
+	}
+
+	stop.ID = primitive.NewObjectID()
+	stop.TripID = trip.ID
+	stop.Position = position
+	stop.CreatedAt = time.Now()
+	if _, err := db.StopCollection.InsertOne(context.Background(), stop); err != nil {
+		http.Error(w, "Failed to create stop", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(stop)
+}
+
+// UpdateStop changes the fields given. Setting day_id moves the stop to the end of that day,
+// or to the unscheduled stops when it's empty.
+func UpdateStop(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	stopID, err := primitive.ObjectIDFromHex(mux.Vars(r)["stop_id"])
+	if err != nil {
+		http.Error(w, "Invalid stop ID format", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		DayID     *primitive.ObjectID `json:"day_id"`
+		Name      *string             `json:"name"`
+		Type      *string             `json:"type"`
+		Location  *string             `json:"location"`
+		Latitude  *float64            `json:"latitude"`
+		Longitude *float64            `json:"longitude"`
+		StartTime *string             `json:"start_time"`
+		EndTime   *string             `json:"end_time"`
+		Notes     *string             `json:"notes"`
+		Cost      *float64            `json:"cost"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleEditor)
+	if !ok {
+		return
+	}
+
+	var stop models.Stop
+	err = db.StopCollection.FindOne(context.Background(), bson.M{"_id": stopID, "trip_id": trip.ID}).Decode(&stop)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Stop not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve stop", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Apply the changes to a copy and validate the result as a whole
+	if body.Name != nil {
+		stop.Name = *body.Name
+	}
+	if body.Type != nil {
+		stop.Type = *body.Type
+	}
+	if body.Location != nil {
+		stop.Location = *body.Location
+	}
+	if body.Latitude != nil {
+		stop.Latitude = body.Latitude
+	}
+	if body.Longitude != nil {
+		stop.Longitude = body.Longitude
+	}
+	if body.StartTime != nil {
+		stop.StartTime = *body.StartTime
+	}
+	if body.EndTime != nil {
+		stop.EndTime = *body.EndTime
+	}
+	if body.Notes != nil {
+		stop.Notes = *body.Notes
+	}
+	if body.Cost != nil {
+		stop.Cost = *body.Cost
+	}
+	if err := validateStop(&stop); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.DayID != nil && *body.DayID != stop.DayID {
+		if !dayOnTrip(w, trip.ID, *body.DayID) {
+			return
+		}
+		stop.DayID = *body.DayID
+		stop.Position, err = nextPosition(context.Background(), db.StopCollection, bson.M{"trip_id": trip.ID, "day_id": stop.DayID})
+		if err != nil {
+			http.Error(w, "Failed to update stop", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	result, err := db.StopCollection.ReplaceOne(context.Background(), bson.M{"_id": stop.ID, "trip_id": trip.ID}, stop)
+	if err != nil {
+		http.Error(w, "Failed to update stop", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Stop not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stop)
+}
+
+// DeleteStop removes a stop from the itinerary
+func DeleteStop(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	stopID, err := primitive.ObjectIDFromHex(mux.Vars(r)["stop_id"])
+	if err != nil {
+		http.Error(w, "Invalid stop ID format", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleEditor)
+	if !ok {
+		return
+	}
+
+	result, err := db.StopCollection.DeleteOne(context.Background(), bson.M{"_id": stopID, "trip_id": trip.ID})
+	if err != nil {
+		http.Error(w, "Failed to delete stop", http.StatusInternalServerError)
+		return
+	}
+	if result.DeletedCount == 0 {
+		http.Error(w, "Stop not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReorderStops puts the stops of one day (or the unscheduled stops, when day_id is left out)
+// in the order of stop_ids, which must list every one of them once
+func ReorderStops(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		DayID   primitive.ObjectID   `json:"day_id"`
+		StopIDs []primitive.ObjectID `json:"stop_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleEditor)
+	if !ok {
+		return
+	}
+
+	err := reorder(context.Background(), db.StopCollection, bson.M{"trip_id": trip.ID, "day_id": body.DayID}, body.StopIDs)
+	if err != nil {
+		if err == errReorderMismatch {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to reorder stops", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MigrateAttractions turns the free-text Attractions of every trip into unscheduled stops, one
+// per comma, semicolon or line separated entry, and clears the text. Each trip is converted in
+// its own transaction, so the migration can safely be run again after a failure.
+func MigrateAttractions(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	cursor, err := db.TripCollection.Find(context.Background(),
+		bson.M{"attractions": bson.M{"$nin": bson.A{"", nil}}},
+		options.Find().SetProjection(bson.M{"attractions": 1}),
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch trips", http.StatusInternalServerError)
+		return
+	}
+	var trips []models.Trip
+	if err := cursor.All(context.Background(), &trips); err != nil {
+		http.Error(w, "Failed to fetch trips", http.StatusInternalServerError)
+		return
+	}
+
+	var report struct {
+		Trips int `json:"trips"`
+		Stops int `json:"stops"`
+	}
+	for _, trip := range trips {
+		created, err := migrateTripAttractions(context.Background(), &trip)
+		if err != nil {
+			http.Error(w, "Failed to migrate trip "+trip.ID.Hex(), http.StatusInternalServerError)
+			return
+		}
+		report.Trips++
+		report.Stops += created
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "maintenance.migrate_attractions",
+		TargetType: "trip",
+		Details:    strconv.Itoa(report.Trips) + " trips, " + strconv.Itoa(report.Stops) + " stops",
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// migrateTripAttractions converts one trip's Attractions into stops and returns how many it created
+func migrateTripAttractions(ctx context.Context, trip *models.Trip) (int, error) {
+	names := splitAttractions(trip.Attractions)
+
+	session, err := db.Client.StartSession()
+	if err != nil {
+		return 0, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// Only convert the text that was read, in case it changed in the meantime
+		result, err := db.TripCollection.UpdateOne(sc,
+			bson.M{"_id": trip.ID, "attractions": trip.Attractions},
+			bson.M{"$unset": bson.M{"attractions": ""}},
+		)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount == 0 || len(names) == 0 {
+			return nil, nil
+		}
+
+		position, err := nextPosition(sc, db.StopCollection, bson.M{"trip_id": trip.ID, "day_id": primitive.NilObjectID})
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		stops := make([]interface{}, len(names))
+		for i, name := range names {
+			stops[i] = models.Stop{
+				ID:        primitive.NewObjectID(),
+				TripID:    trip.ID,
+				Position:  position + i,
+				Name:      name,
+				Type:      models.StopTypeAttraction,
+				CreatedAt: now,
+			}
+		}
+		_, err = db.StopCollection.InsertMany(sc, stops)
+		return nil, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(names), nil
+}
+
+// splitAttractions breaks a free-text attractions list into trimmed, non-empty names
+func splitAttractions(text string) []string {
+	var names []string
+	for _, part := range strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n' || r == '\r'
+	}) {
+		if name := strings.TrimSpace(part); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// validateStop checks the fields a client can set on a stop
+func validateStop(stop *models.Stop) error {
+	stop.Name = strings.TrimSpace(stop.Name)
+	if stop.Name == "" {
+		return errors.New("Name must be provided")
+	}
+	if !models.ValidStopType(stop.Type) {
+		return errors.New("Type must be one of attraction, activity, food, lodging, transport, other")
+	}
+	var start, end time.Time
+	var err error
+	if stop.StartTime != "" {
+		if start, err = time.Parse("15:04", stop.StartTime); err != nil {
+			return errors.New("start_time must be HH:MM")
+		}
+	}
+	if stop.EndTime != "" {
+		if end, err = time.Parse("15:04", stop.EndTime); err != nil {
+			return errors.New("end_time must be HH:MM")
+		}
+	}
+	if stop.StartTime != "" && stop.EndTime != "" && end.Before(start) {
+		return errors.New("end_time can't be before start_time")
+	}
+	if stop.Latitude != nil && (math.IsNaN(*stop.Latitude) || *stop.Latitude < -90 || *stop.Latitude > 90) {
+		return errors.New("latitude must be between -90 and 90")
+	}
+	if stop.Longitude != nil && (math.IsNaN(*stop.Longitude) || *stop.Longitude < -180 || *stop.Longitude > 180) {
+		return errors.New("longitude must be between -180 and 180")
+	}
+	if stop.Cost < 0 || math.IsNaN(stop.Cost) {
+		return errors.New("cost can't be negative")
+	}
+	return nil
+}
+
+// dayOnTrip checks a stop's day belongs to the trip, writing the error response if it doesn't.
+// A nil day means unscheduled and is always fine.
+func dayOnTrip(w http.ResponseWriter, tripID, dayID primitive.ObjectID) bool {
+	if dayID.IsZero() {
+		return true
+	}
+	count, err := db.TripDayCollection.CountDocuments(context.Background(), bson.M{"_id": dayID, "trip_id": tripID})
+	if err != nil {
+		http.Error(w, "Failed to retrieve day", http.StatusInternalServerError)
+		return false
+	}
+	if count == 0 {
+		http.Error(w, "Day not found", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// findStops loads the stops matching filter ordered by day and position
+func findStops(ctx context.Context, filter bson.M) ([]models.Stop, error) {
+	cursor, err := db.StopCollection.Find(ctx, filter,
+		options.Find().SetSort(bson.D{{Key: "day_id", Value: 1}, {Key: "position", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	stops := []models.Stop{}
+	if err := cursor.All(ctx, &stops); err != nil {
+		return nil, err
+	}
+	return stops, nil
+}
+
+// nextPosition returns the position after the last document matching filter
+func nextPosition(ctx context.Context, collection *mongo.Collection, filter bson.M) (int, error) {
+	var last struct {
+		Position int `bson:"position"`
+	}
+	err := collection.FindOne(ctx, filter,
+		options.FindOne().SetSort(bson.D{{Key: "position", Value: -1}}).SetProjection(bson.M{"position": 1}),
+	).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return last.Position + 1, nil
+}
+
+// reorder renumbers the documents matching filter in the order of ids, which must name each of
+// them exactly once. The check and the renumbering run in one transaction so a document added
+// or moved in between can't end up sharing a position.
+func reorder(ctx context.Context, collection *mongo.Collection, filter bson.M, ids []primitive.ObjectID) error {
+	session, err := db.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		existing, err := collection.Distinct(sc, "_id", filter)
+		if err != nil {
+			return nil, err
+		}
+		if len(existing) != len(ids) {
+			return nil, errReorderMismatch
+		}
+		known := make(map[primitive.ObjectID]bool, len(existing))
+		for _, id := range existing {
+			if oid, ok := id.(primitive.ObjectID); ok {
+				known[oid] = true
+			}
+		}
+		writes := make([]mongo.WriteModel, len(ids))
+		for i, id := range ids {
+			if !known[id] {
+				return nil, errReorderMismatch
+			}
+			delete(known, id) // a repeated ID fails the check above on its second appearance
+			match := bson.M{"_id": id}
+			for field, value := range filter {
+				match[field] = value
+			}
+			writes[i] = mongo.NewUpdateOneModel().
+				SetFilter(match).
+				SetUpdate(bson.M{"$set": bson.M{"position": i}})
+		}
+		if len(writes) == 0 {
+			return nil, nil
+		}
+		result, err := collection.BulkWrite(sc, writes)
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount != int64(len(writes)) {
+			return nil, errReorderMismatch
+		}
+		return nil, nil
+	})
+	return err
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"trip-planner/db"
+	"trip-planner/middleware"
+	"trip-planner/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OrphanReport lists comments whose trip or author no longer exists, and credentials left
+// behind by deleted users
+type OrphanReport struct {
+	MissingTrip []primitive.ObjectID            `json:"missing_trip"` // comments on deleted trips
+	MissingUser []primitive.ObjectID            `json:"missing_user"` // comments by deleted users that still name them
+	Credentials map[string]*OrphanedCredentials `json:"credentials"`  // keyed by collection
+	Cleaned     bool                            `json:"cleaned"`
+}
+
+// OrphanedCredentials counts the API keys, sessions or tokens in one collection whose user no
+// longer exists
+type OrphanedCredentials struct {
+	Count   int64                `json:"count"`
+	UserIDs []primitive.ObjectID `json:"user_ids"`
+}
+
+// ScanOrphans reports orphaned comments and credentials without changing anything
+func ScanOrphans(w http.ResponseWriter, r *http.Request) {
+	report, err := findOrphans(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to scan for orphans", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// CleanOrphans deletes comments on trips that no longer exist, detaches comments from users
+// that no longer exist and deletes those users' credentials, the same way deleting an account
+// does. It answers with what it fixed.
+func CleanOrphans(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	report, err := findOrphans(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to scan for orphans", http.StatusInternalServerError)
+		return
+	}
+
+	if len(report.MissingTrip) > 0 {
+		_, err := db.CommentCollection.DeleteMany(context.Background(), bson.M{"_id": bson.M{"$in": report.MissingTrip}})
+		if err != nil {
+			http.Error(w, "Failed to clean orphaned comments", http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(report.MissingUser) > 0 {
+		_, err := db.CommentCollection.UpdateMany(context.Background(),
+			bson.M{"_id": bson.M{"$in": report.MissingUser}},
+			bson.M{"$set": bson.M{"user_id": primitive.NilObjectID}},
+		)
+		if err != nil {
+			http.Error(w, "Failed to clean orphaned comments", http.StatusInternalServerError)
+			return
+		}
+	}
+	var credentials int64
+	for _, collection := range userCredentialCollections() {
+		orphans := report.Credentials[collection.Name()]
+		if orphans == nil || len(orphans.UserIDs) == 0 {
+			continue
+		}
+		result, err := collection.DeleteMany(context.Background(), bson.M{"user_id": bson.M{"$in": orphans.UserIDs}})
+		if err != nil {
+			http.Error(w, "Failed to clean orphaned credentials", http.StatusInternalServerError)
+			return
+		}
+		credentials += result.DeletedCount
+	}
+	report.Cleaned = true
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "maintenance.clean_orphans",
+		TargetType: "comment",
+		Details: "deleted " + strconv.Itoa(len(report.MissingTrip)) + ", detached " + strconv.Itoa(len(report.MissingUser)) +
+			", removed " + strconv.FormatInt(credentials, 10) + " credentials",
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// findOrphans looks up comments whose trip is gone, comments whose author is gone and
+// credentials whose user is gone. Comments already detached from a deleted account have a nil
+// author and don't count.
+func findOrphans(ctx context.Context) (*OrphanReport, error) {
+	missingTrip, err := orphanedCommentIDs(ctx, "trip_id", "trips", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	missingUser, err := orphanedCommentIDs(ctx, "user_id", "users", bson.M{"user_id": bson.M{"$ne": primitive.NilObjectID}})
+	if err != nil {
+		return nil, err
+	}
+	report := &OrphanReport{
+		MissingTrip: missingTrip,
+		MissingUser: missingUser,
+		Credentials: map[string]*OrphanedCredentials{},
+	}
+	for _, collection := range userCredentialCollections() {
+		orphans, err := orphanedCredentials(ctx, collection)
+		if err != nil {
+			return nil, err
+		}
+		report.Credentials[collection.Name()] = orphans
+	}
+	return report, nil
+}
+
+// orphanedCredentials counts the documents in the collection whose user_id points at no user
+func orphanedCredentials(ctx context.Context, collection *mongo.Collection) (*OrphanedCredentials, error) {
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.M{"from": "users", "localField": "user_id", "foreignField": "_id", "as": "owner"}}},
+		{{Key: "$match", Value: bson.M{"owner": bson.M{"$size": 0}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$user_id", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		UserID primitive.ObjectID `bson:"_id"`
+		Count  int64              `bson:"count"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	orphans := &OrphanedCredentials{UserIDs: []primitive.ObjectID{}}
+	for _, row := range rows {
+		orphans.Count += row.Count
+		orphans.UserIDs = append(orphans.UserIDs, row.UserID)
+	}
+	return orphans, nil
+}
+
+// userCredentialCollections lists the collections holding a user's API keys, sessions and
+// tokens, which go when the user does. Revocations are kept until they expire.
+func userCredentialCollections() []*mongo.Collection {
+	return []*mongo.Collection{
+		db.APIKeyCollection,
+		db.SessionCollection,
+		db.UserTokenCollection,
+		db.RefreshTokenCollection,
+	}
+}
+
+// orphanedCommentIDs returns the IDs of comments matching filter whose field points at no
+// document in the collection
+func orphanedCommentIDs(ctx context.Context, field, collection string, filter bson.M) ([]primitive.ObjectID, error) {
+	cursor, err := db.CommentCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$lookup", Value: bson.M{"from": collection, "localField": field, "foreignField": "_id", "as": "parent"}}},
+		{{Key: "$match", Value: bson.M{"parent": bson.M{"$size": 0}}}},
+		{{Key: "$project", Value: bson.M{"_id": 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	return ids, nil
+}
+
+// deleteTripCascade deletes the trip matching filter together with its comments, invites,
+// share links, itinerary and expenses, in one transaction. Members are stored on the trip
+// and go with it. It returns mongo.ErrNoDocuments if no trip matches.
+func deleteTripCascade(ctx context.Context, filter bson.M) (*models.Trip, error) {
+	session, err := db.Client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	var trip models.Trip
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		if err := db.TripCollection.FindOneAndDelete(sc, filter).Decode(&trip); err != nil {
+			return nil, err
+		}
+		return nil, deleteTripDependents(sc, []interface{}{trip.ID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &trip, nil
+}
+
+// deleteTripDependents removes everything that belongs to the trips. Call it inside the
+// transaction that deletes the trips themselves.
+func deleteTripDependents(sc mongo.SessionContext, tripIDs []interface{}) error {
+	filter := bson.M{"trip_id": bson.M{"$in": tripIDs}}
+	for _, collection := range []*mongo.Collection{
+		db.CommentCollection,
+		db.TripInviteCollection,
+		db.ShareLinkCollection,
+		db.TripDayCollection,
+		db.StopCollection,
+		db.ExpenseCollection,
+	} {
+		if _, err := collection.DeleteMany(sc, filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"trip-planner/db"
+	"trip-planner/middleware"
+	"trip-planner/models"
+	"trip-planner/policy"
+	"trip-planner/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListTripMembers returns everyone with access to the trip. Any member may see the list.
+func ListTripMembers(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleViewer)
+	if !ok {
+		return
+	}
+
+	members := append([]models.TripMember{{UserID: trip.UserID, Role: models.TripRoleOwner}}, trip.Members...)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// AddTripMember gives another user access to the trip. The user is named by user_id, email or
+// username. Only owners may add members.
+func AddTripMember(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		UserID   string `json:"user_id"`
+		Email    string `json:"email"`
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if !models.ValidTripRole(body.Role) {
+		http.Error(w, "Role must be one of owner, editor, viewer", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleOwner)
+	if !ok {
+		return
+	}
+
+	// Find the user being added
+	var filter bson.M
+	switch {
+	case body.UserID != "":
+		userID, err := primitive.ObjectIDFromHex(body.UserID)
+		if err != nil {
+			http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+			return
+		}
+		filter = bson.M{"_id": userID}
+	case body.Email != "":
+		filter = bson.M{"email": utils.NormalizeEmail(body.Email)}
+	case body.Username != "":
+		filter = bson.M{"username": body.Username}
+	default:
+		http.Error(w, "user_id, email or username must be provided", http.StatusBadRequest)
+		return
+	}
+	var user models.User
+	err := db.UserCollection.FindOne(context.Background(), filter, options.FindOne().SetCollation(db.CaseInsensitive)).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "User not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		}
+		return
+	}
+	if user.Disabled {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if trip.MemberRole(user.ID) != "" {
+		http.Error(w, "User is already a member of this trip", http.StatusConflict)
+		return
+	}
+
+	// The filter guards against the same user being added concurrently
+	member := models.TripMember{UserID: user.ID, Role: body.Role, AddedAt: time.Now()}
+	result, err := db.TripCollection.UpdateOne(context.Background(),
+		bson.M{"_id": trip.ID, "user_id": bson.M{"$ne": user.ID}, "members.user_id": bson.M{"$ne": user.ID}},
+		bson.M{"$push": bson.M{"members": member}},
+	)
+	if err != nil {
+		http.Error(w, "Failed to add member", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "User is already a member of this trip", http.StatusConflict)
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "trip.member_add",
+		TargetType: "trip",
+		TargetID:   trip.ID,
+		Details:    "user " + user.ID.Hex() + " as " + body.Role,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(member)
+}
+
+// UpdateTripMember changes a member's role. Only owners may change roles, and the trip's
+// creator always stays an owner.
+func UpdateTripMember(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	memberID, err := primitive.ObjectIDFromHex(mux.Vars(r)["user_id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if !models.ValidTripRole(body.Role) {
+		http.Error(w, "Role must be one of owner, editor, viewer", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleOwner)
+	if !ok {
+		return
+	}
+	if memberID == trip.UserID {
+		http.Error(w, "The trip's creator is always an owner", http.StatusBadRequest)
+		return
+	}
+
+	// An array filter rather than the positional operator, so the update can't land on another member
+	result, err := db.TripCollection.UpdateOne(context.Background(),
+		bson.M{"_id": trip.ID, "members.user_id": memberID},
+		bson.M{"$set": bson.M{"members.$[member].role": body.Role}},
+		options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"member.user_id": memberID}},
+		}),
+	)
+	if err != nil {
+		http.Error(w, "Failed to update member", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "trip.member_update",
+		TargetType: "trip",
+		TargetID:   trip.ID,
+		Details:    "user " + memberID.Hex() + " as " + body.Role,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveTripMember takes a member off the trip. Owners may remove anyone but the creator,
+// and any member may remove themselves.
+func RemoveTripMember(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	memberID, err := primitive.ObjectIDFromHex(mux.Vars(r)["user_id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+
+	minRole := models.TripRoleOwner
+	if memberID == principal.UserID {
+		minRole = models.TripRoleViewer
+	}
+	trip, ok := loadTripFor(w, r, principal, minRole)
+	if !ok {
+		return
+	}
+	if memberID == trip.UserID {
+		http.Error(w, "The trip's creator can't be removed", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.TripCollection.UpdateOne(context.Background(),
+		bson.M{"_id": trip.ID, "members.user_id": memberID},
+		bson.M{"$pull": bson.M{"members": bson.M{"user_id": memberID}}},
+	)
+	if err != nil {
+		http.Error(w, "Failed to remove member", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Member not found", http.StatusNotFound)
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "trip.member_remove",
+		TargetType: "trip",
+		TargetID:   trip.ID,
+		Details:    "user " + memberID.Hex(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadTripFor fetches the trip in the path if the caller holds at least minRole on it,
+// writing the error response otherwise
+func loadTripFor(w http.ResponseWriter, r *http.Request, principal *middleware.Principal, minRole string) (*models.Trip, bool) {
+	trip, ok := loadPathTrip(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	// Members who lack the role learn the trip exists, everyone else gets a 404
+	if !policy.CanAccessTrip(principal.UserID, principal.Role, trip, minRole) {
+		if policy.CanAccessTrip(principal.UserID, principal.Role, trip, models.TripRoleViewer) {
+			http.Error(w, "You do not have permission to do this", http.StatusForbidden)
+		} else {
+			http.Error(w, "Trip not found", http.StatusNotFound)
+		}
+		return nil, false
+	}
+	return trip, true
+}
+
+// loadReadableTrip fetches the trip in the path if the caller is a member or the trip is
+// public or unlisted, writing the error response otherwise
+func loadReadableTrip(w http.ResponseWriter, r *http.Request, principal *middleware.Principal) (*models.Trip, bool) {
+	trip, ok := loadPathTrip(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !trip.OpenToNonMembers() && !policy.CanAccessTrip(principal.UserID, principal.Role, trip, models.TripRoleViewer) {
+		http.Error(w, "Trip not found", http.StatusNotFound)
+		return nil, false
+	}
+	return trip, true
+}
+
+// loadPathTrip fetches the trip named by the {id} path variable, writing the error response if
+// it can't
+func loadPathTrip(w http.ResponseWriter, r *http.Request) (*models.Trip, bool) {
+	tripID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid trip ID format", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var trip models.Trip
+	err = db.TripCollection.FindOne(context.Background(), bson.M{"_id": tripID}).Decode(&trip)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Trip not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve trip", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return &trip, true
+}
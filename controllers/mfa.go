@@ -0,0 +1,350 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"trip-planner/db"
+	"trip-planner/middleware"
+	"trip-planner/models"
+	"trip-planner/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// recoveryCodeCount is how many one-time recovery codes are issued at a time
+const recoveryCodeCount = 10
+
+// EnrollMFA starts TOTP enrollment by generating a secret the user adds to their authenticator app.
+// Nothing changes for login until the secret is confirmed with a code.
+func EnrollMFA(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	user, err := findUser(principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return
+	}
+	if user.MFAEnabled {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	_, err = db.UserCollection.UpdateOne(context.Background(), bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"mfa_pending_secret": secret}})
+	if err != nil {
+		http.Error(w, "Failed to start enrollment", http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}{
+		Secret:     secret,
+		OTPAuthURI: utils.TOTPURI(secret, user.Email),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ConfirmMFA enables two-factor authentication once the user proves their app produces valid codes.
+// The recovery codes are only ever shown in this response.
+func ConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	user, err := findUser(principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return
+	}
+	if user.MFAPendingSecret == "" {
+		http.Error(w, "No enrollment in progress", http.StatusBadRequest)
+		return
+	}
+
+	step, ok := utils.ValidateTOTP(user.MFAPendingSecret, body.Code, time.Now())
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	_, err = db.UserCollection.UpdateOne(context.Background(),
+		bson.M{"_id": user.ID, "mfa_pending_secret": user.MFAPendingSecret},
+		bson.M{
+			"$set": bson.M{
+				"mfa_enabled":         true,
+				"mfa_secret":          user.MFAPendingSecret,
+				"mfa_last_step":       step,
+				"mfa_recovery_hashes": hashes,
+			},
+			"$unset": bson.M{"mfa_pending_secret": ""},
+		},
+	)
+	if err != nil {
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	writeRecoveryCodes(w, codes)
+}
+
+// DisableMFA turns two-factor authentication off. It needs the password and a current code
+// (or a recovery code) so a stolen session alone can't remove the second factor. Accounts
+// without a password need a fresh login in place of it.
+func DisableMFA(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	user, err := findUser(principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return
+	}
+	if !user.MFAEnabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	if user.Password == "" {
+		if !confirmPasswordless(w, principal, user, "") {
+			return
+		}
+	} else if !utils.VerifyPassword(user.Password, body.Password) {
+		http.Error(w, "Password is incorrect", http.StatusForbidden)
+		return
+	}
+	ok, err := verifySecondFactor(user, body.Code, body.RecoveryCode)
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusForbidden)
+		return
+	}
+
+	_, err = db.UserCollection.UpdateOne(context.Background(),
+		bson.M{"_id": user.ID},
+		bson.M{
+			"$set":   bson.M{"mfa_enabled": false},
+			"$unset": bson.M{"mfa_secret": "", "mfa_pending_secret": "", "mfa_last_step": "", "mfa_recovery_hashes": ""},
+		},
+	)
+	if err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces every recovery code after checking a current TOTP code
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	user, err := findUser(principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return
+	}
+	if !user.MFAEnabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	ok, err := verifySecondFactor(user, body.Code, "")
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "Invalid code", http.StatusForbidden)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	_, err = db.UserCollection.UpdateOne(context.Background(), bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"mfa_recovery_hashes": hashes}})
+	if err != nil {
+		http.Error(w, "Failed to store recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	writeRecoveryCodes(w, codes)
+}
+
+// LoginMFA completes a two-factor login by exchanging the "mfa pending" token and a code
+// for the real access and refresh tokens
+func LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.MFAToken == "" {
+		http.Error(w, "MFA token must be provided", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := utils.ValidateMFAToken(body.MFAToken)
+	if err != nil {
+		middleware.Unauthorized(w)
+		return
+	}
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		middleware.Unauthorized(w)
+		return
+	}
+
+	// Code guesses count against the same lockout as password guesses
+	ip := utils.ClientIP(r)
+	account := "user:" + userID.Hex()
+	if wait := loginLockout(context.Background(), ip, account); wait > 0 {
+		auditLogin(r, userID, "", models.AuditFailure, "locked out")
+		setRetryAfter(w, wait)
+		http.Error(w, "Too many login attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	user, err := findUser(userID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			middleware.Unauthorized(w)
+		} else {
+			http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		}
+		return
+	}
+	if user.Disabled || !user.MFAEnabled {
+		middleware.Unauthorized(w)
+		return
+	}
+
+	ok, err := verifySecondFactor(user, body.Code, body.RecoveryCode)
+	if err != nil {
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		if wait := recordLoginFailure(context.Background(), ip, account); wait > 0 {
+			setRetryAfter(w, wait)
+		}
+		auditLogin(r, user.ID, "", models.AuditFailure, "invalid second factor")
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	recordLoginSuccess(context.Background(), account)
+	auditLogin(r, user.ID, "", models.AuditSuccess, "second factor accepted")
+
+	// The pending token is single-use
+	if err := utils.RevokeAccessToken(context.Background(), claims, "mfa completed"); err != nil {
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := utils.IssueTokenPair(context.Background(), user, utils.ClientInfoFromRequest(r))
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// verifySecondFactor accepts either a TOTP code, which must be newer than the last one used,
+// or an unused recovery code, which is consumed
+func verifySecondFactor(user *models.User, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		hash := utils.HashToken(utils.NormalizeRecoveryCode(recoveryCode))
+		result, err := db.UserCollection.UpdateOne(context.Background(),
+			bson.M{"_id": user.ID, "mfa_recovery_hashes": hash},
+			bson.M{"$pull": bson.M{"mfa_recovery_hashes": hash}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return result.ModifiedCount == 1, nil
+	}
+
+	step, ok := utils.ValidateTOTP(user.MFASecret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// Advance the last used step atomically so the same code can't be replayed
+	result, err := db.UserCollection.UpdateOne(context.Background(),
+		bson.M{"_id": user.ID, "mfa_last_step": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"mfa_last_step": step}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// newRecoveryCodes returns fresh recovery codes and the hashes stored for them
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = utils.HashToken(code)
+	}
+	return codes, hashes, nil
+}
+
+func writeRecoveryCodes(w http.ResponseWriter, codes []string) {
+	response := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,303 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"trip-planner/db"
+	"trip-planner/models"
+	"trip-planner/oidc"
+	"trip-planner/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// oidcStateTTL bounds how long a user may spend at the identity provider
+const oidcStateTTL = 10 * time.Minute
+
+// oidcStateCookie ties a login to the browser that started it. It holds the hash of the state.
+const oidcStateCookie = "oidc_state"
+
+var usernameUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// OIDCLogin starts an authorization-code-with-PKCE login by redirecting to the identity provider
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider := oidc.Default
+	if provider == nil {
+		http.Error(w, "OIDC login is not configured", http.StatusNotFound)
+		return
+	}
+
+	state, err := utils.RandomToken(32)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := utils.RandomToken(32)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	verifier, err := utils.RandomToken(48)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	_, err = db.OIDCStateCollection.InsertOne(context.Background(), models.OIDCState{
+		ID:           primitive.NewObjectID(),
+		StateHash:    utils.HashToken(state),
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(oidcStateTTL),
+	})
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	setStateCookie(w, provider, state)
+	http.Redirect(w, r, provider.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+// OIDCCallback completes the login: it checks the state, redeems the code, links or
+// provisions the local user and issues the usual tokens
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider := oidc.Default
+	if provider == nil {
+		http.Error(w, "OIDC login is not configured", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		http.Error(w, "Identity provider returned an error: "+errCode, http.StatusUnauthorized)
+		return
+	}
+	state, code := query.Get("state"), query.Get("code")
+	if state == "" || code == "" {
+		http.Error(w, "Missing state or code", http.StatusBadRequest)
+		return
+	}
+
+	// A state is only good in the browser it was issued to, so nobody can finish a login
+	// they started in someone else's browser
+	stateMatches := checkStateCookie(r, state)
+	clearStateCookie(w, provider)
+	if !stateMatches {
+		http.Error(w, "Login was not started in this browser", http.StatusBadRequest)
+		return
+	}
+
+	// Each state can only complete one login
+	var saved models.OIDCState
+	err := db.OIDCStateCollection.FindOneAndDelete(context.Background(), bson.M{
+		"state_hash": utils.HashToken(state),
+		"expires_at": bson.M{"$gt": time.Now()},
+	}).Decode(&saved)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Invalid or expired state", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to complete login", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+	claims, err := provider.Exchange(ctx, code, saved.CodeVerifier, saved.Nonce)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		http.Error(w, "Failed to verify identity", http.StatusUnauthorized)
+		return
+	}
+
+	user, status, message := userForIdentity(r, provider.Issuer, claims)
+	if user == nil {
+		http.Error(w, message, status)
+		return
+	}
+	if user.Disabled {
+		auditLogin(r, user.ID, claims.Subject, models.AuditFailure, "account disabled")
+		http.Error(w, "Account disabled", http.StatusForbidden)
+		return
+	}
+	if emailVerificationRequired() && !user.EmailVerified {
+		auditLogin(r, user.ID, claims.Subject, models.AuditFailure, "email not verified")
+		http.Error(w, "Email not verified", http.StatusForbidden)
+		return
+	}
+
+	auditLogin(r, user.ID, claims.Subject, models.AuditSuccess, "via "+provider.Issuer)
+	respondWithLogin(w, r, user)
+}
+
+// setStateCookie stores the hash of the login's state in a short-lived cookie scoped to the
+// callback. It's Lax rather than Strict because the identity provider redirects back cross-site.
+func setStateCookie(w http.ResponseWriter, provider *oidc.Provider, state string) {
+	http.SetCookie(w, stateCookie(provider, utils.HashToken(state), int(oidcStateTTL.Seconds())))
+}
+
+// clearStateCookie removes the state cookie once the callback has used it
+func clearStateCookie(w http.ResponseWriter, provider *oidc.Provider) {
+	http.SetCookie(w, stateCookie(provider, "", -1))
+}
+
+func stateCookie(provider *oidc.Provider, value string, maxAge int) *http.Cookie {
+	path := "/"
+	if callback, err := url.Parse(provider.RedirectURL); err == nil && callback.Path != "" {
+		path = callback.Path
+	}
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(provider.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// checkStateCookie reports whether the request carries the state cookie for state
+func checkStateCookie(r *http.Request, state string) bool {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(utils.HashToken(state))) == 1
+}
+
+// userForIdentity finds the user linked to the external identity, links an existing account
+// with the same verified email, or provisions a new user. On failure it returns the HTTP
+// status and message to answer with.
+func userForIdentity(r *http.Request, issuer string, claims *oidc.IDClaims) (*models.User, int, string) {
+	identity := models.Identity{Issuer: issuer, Subject: claims.Subject}
+
+	var user models.User
+	err := db.UserCollection.FindOne(context.Background(), bson.M{
+		"identities": bson.M{"$elemMatch": bson.M{"issuer": identity.Issuer, "subject": identity.Subject}},
+	}).Decode(&user)
+	if err == nil {
+		return &user, 0, ""
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, http.StatusInternalServerError, "Failed to look up user"
+	}
+
+	email := utils.NormalizeEmail(claims.Email)
+	if email == "" || utils.ValidateEmail(email) != nil {
+		return nil, http.StatusBadRequest, "Identity provider did not return a usable email"
+	}
+
+	// Link an existing account with the same email
+	err = db.UserCollection.FindOne(context.Background(), bson.M{"email": email}, options.FindOne().SetCollation(db.CaseInsensitive)).Decode(&user)
+	if err == nil {
+		if status, message := identityRefusal(claims, true); status != 0 {
+			return nil, status, message
+		}
+		err = db.UserCollection.FindOneAndUpdate(context.Background(),
+			bson.M{"_id": user.ID},
+			bson.M{
+				"$addToSet": bson.M{"identities": identity},
+				"$set":      bson.M{"email_verified": true},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&user)
+		if err == nil {
+			err = promoteListedAdmin(r, &user)
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, "Failed to link account"
+		}
+		return &user, 0, ""
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, http.StatusInternalServerError, "Failed to look up user"
+	}
+
+	// Provision a new password-less user
+	if status, message := identityRefusal(claims, false); status != 0 {
+		return nil, status, message
+	}
+	user = models.User{
+		ID:            primitive.NewObjectID(),
+		Email:         email,
+		Role:          provisionedRole(email, claims),
+		EmailVerified: claims.EmailVerified,
+		DisplayName:   claims.Name,
+		Identities:    []models.Identity{identity},
+	}
+	base := usernameFromClaims(claims)
+	for attempt := 0; attempt < 5; attempt++ {
+		user.Username = base
+		if attempt > 0 {
+			suffix, err := utils.RandomToken(3)
+			if err != nil {
+				return nil, http.StatusInternalServerError, "Failed to create user"
+			}
+			user.Username = base + "-" + strings.ToLower(usernameUnsafeChars.ReplaceAllString(suffix, ""))
+		}
+
+		_, err = db.UserCollection.InsertOne(context.Background(), user)
+		if err == nil {
+			return &user, 0, ""
+		}
+		if duplicateUserField(err) != "username" {
+			break
+		}
+	}
+	return nil, http.StatusInternalServerError, "Failed to create user"
+}
+
+// identityRefusal decides whether an identity may be linked to the existing account with its
+// email, or provision a new one when there is none. Linking needs a verified email, otherwise
+// anyone could claim the account, and there's no point creating an account that couldn't log
+// in. It returns the HTTP status and message to refuse with, or 0 when the identity is accepted.
+func identityRefusal(claims *oidc.IDClaims, existing bool) (int, string) {
+	switch {
+	case claims.EmailVerified:
+		return 0, ""
+	case existing:
+		return http.StatusConflict, "An account with this email already exists; verify the email with your identity provider to link it"
+	case emailVerificationRequired():
+		return http.StatusForbidden, "Email not verified; verify it with your identity provider first"
+	}
+	return 0, ""
+}
+
+// provisionedRole is the role of a user created from an identity. The admin list only applies
+// to addresses the provider has verified.
+func provisionedRole(email string, claims *oidc.IDClaims) string {
+	if !claims.EmailVerified {
+		return models.RoleUser
+	}
+	return initialRole(email)
+}
+
+// usernameFromClaims derives a valid username from preferred_username or the email local part
+func usernameFromClaims(claims *oidc.IDClaims) string {
+	candidate := claims.PreferredUsername
+	if candidate == "" || strings.Contains(candidate, "@") {
+		candidate, _, _ = strings.Cut(claims.Email, "@")
+	}
+	candidate = usernameUnsafeChars.ReplaceAllString(candidate, "")
+	if len(candidate) > 24 {
+		candidate = candidate[:24]
+	}
+	for len(candidate) < 3 {
+		candidate += "_"
+	}
+	return candidate
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"trip-planner/models"
+	"trip-planner/oidc"
+	"trip-planner/oidc/oidctest"
+)
+
+// signInAt runs a full authorization code login for the identity against the mock IdP and
+// returns the verified claims, as OIDCCallback would receive them
+func signInAt(t *testing.T, idp *oidctest.IdP, provider *oidc.Provider, identity oidctest.Identity) *oidc.IDClaims {
+	t.Helper()
+	redirect, err := idp.Authorize(provider.AuthCodeURL("state-1", "nonce-1", "verifier-1"), identity)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if got := redirect.Query().Get("state"); got != "state-1" {
+		t.Fatalf("state = %q, want state-1", got)
+	}
+	claims, err := provider.Exchange(context.Background(), redirect.Query().Get("code"), "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	return claims
+}
+
+func TestOIDCIdentityLinking(t *testing.T) {
+	idp, err := oidctest.New("trip-planner")
+	if err != nil {
+		t.Fatalf("starting IdP: %v", err)
+	}
+	defer idp.Close()
+	provider, err := oidc.Discover(context.Background(), idp.Issuer(), "trip-planner", "",
+		"https://trips.example.com/auth/oidc/callback", []string{"openid", "email"})
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+
+	t.Setenv("ADMIN_EMAILS", "root@example.com")
+	tests := []struct {
+		name       string
+		email      string
+		verified   bool
+		existing   bool // an account with the email already exists
+		require    bool // REQUIRE_EMAIL_VERIFICATION
+		wantStatus int
+		wantRole   string // of a provisioned account
+	}{
+		{name: "link on verified email", email: "alice@example.com", verified: true, existing: true},
+		{name: "refuse link on unverified email", email: "alice@example.com", existing: true, wantStatus: http.StatusConflict},
+		{name: "refuse link on unverified email when required", email: "alice@example.com", existing: true, require: true, wantStatus: http.StatusConflict},
+		{name: "provision unverified", email: "bob@example.com", wantRole: models.RoleUser},
+		{name: "refuse unverified when required", email: "bob@example.com", require: true, wantStatus: http.StatusForbidden},
+		{name: "provision verified", email: "bob@example.com", verified: true, require: true, wantRole: models.RoleUser},
+		{name: "listed admin verified", email: "root@example.com", verified: true, wantRole: models.RoleAdmin},
+		{name: "listed admin unverified", email: "root@example.com", wantRole: models.RoleUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.require {
+				t.Setenv("REQUIRE_EMAIL_VERIFICATION", "true")
+			}
+			claims := signInAt(t, idp, provider, oidctest.Identity{
+				Subject:       "sub-" + tt.email,
+				Email:         tt.email,
+				EmailVerified: tt.verified,
+			})
+			if claims.EmailVerified != tt.verified {
+				t.Fatalf("email_verified = %v, want %v", claims.EmailVerified, tt.verified)
+			}
+
+			status, message := identityRefusal(claims, tt.existing)
+			if status != tt.wantStatus {
+				t.Fatalf("identityRefusal = %d %q, want %d", status, message, tt.wantStatus)
+			}
+			if status == 0 && !tt.existing {
+				if role := provisionedRole(tt.email, claims); role != tt.wantRole {
+					t.Errorf("provisionedRole = %q, want %q", role, tt.wantRole)
+				}
+			}
+		})
+	}
+}
+
+func TestOIDCStateCookie(t *testing.T) {
+	provider := &oidc.Provider{RedirectURL: "https://trips.example.com/auth/oidc/callback"}
+
+	recorder := httptest.NewRecorder()
+	setStateCookie(recorder, provider, "state-1")
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("got %d cookies, want 1", len(cookies))
+	}
+	cookie := cookies[0]
+	if cookie.Value == "state-1" || cookie.Value == "" {
+		t.Errorf("cookie holds %q, want the hash of the state", cookie.Value)
+	}
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie flags HttpOnly=%v Secure=%v SameSite=%v", cookie.HttpOnly, cookie.Secure, cookie.SameSite)
+	}
+	if cookie.Path != "/auth/oidc/callback" || cookie.MaxAge <= 0 {
+		t.Errorf("cookie path %q, max age %d", cookie.Path, cookie.MaxAge)
+	}
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		state  string
+		want   bool
+	}{
+		{name: "same browser", cookie: cookie, state: "state-1", want: true},
+		{name: "other state", cookie: cookie, state: "state-2", want: false},
+		{name: "no cookie", state: "state-1", want: false},
+		{name: "raw state in cookie", cookie: &http.Cookie{Name: oidcStateCookie, Value: "state-1"}, state: "state-1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?state="+tt.state+"&code=x", nil)
+			if tt.cookie != nil {
+				r.AddCookie(&http.Cookie{Name: tt.cookie.Name, Value: tt.cookie.Value})
+			}
+			if got := checkStateCookie(r, tt.state); got != tt.want {
+				t.Errorf("checkStateCookie = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
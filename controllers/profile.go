@@ -0,0 +1,374 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"trip-planner/db"
+	"trip-planner/middleware"
+	"trip-planner/models"
+	"trip-planner/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// recentLoginWindow is how recently an account without a password must have signed in to make
+// changes that ask others for their password
+const recentLoginWindow = 5 * time.Minute
+
+// Upper bounds for the free-text profile fields
+const (
+	maxDisplayNameLength = 64
+	maxHomeRegionLength  = 64
+	maxBioLength         = 500
+	maxAvatarURLLength   = 2048
+)
+
+// GetMe returns the caller's own profile
+func GetMe(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	user, err := findUser(principal.UserID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "User not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// UpdateMe edits the caller's profile fields. Omitted fields are left unchanged, empty strings clear them.
+func UpdateMe(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		DisplayName *string `json:"display_name"`
+		AvatarURL   *string `json:"avatar_url"`
+		HomeRegion  *string `json:"home_region"`
+		Bio         *string `json:"bio"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	updateFields := bson.M{}
+	fields := []struct {
+		name  string
+		value *string
+		limit int
+	}{
+		{"display_name", body.DisplayName, maxDisplayNameLength},
+		{"avatar_url", body.AvatarURL, maxAvatarURLLength},
+		{"home_region", body.HomeRegion, maxHomeRegionLength},
+		{"bio", body.Bio, maxBioLength},
+	}
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
+		value := strings.TrimSpace(*field.value)
+		if len([]rune(value)) > field.limit {
+			http.Error(w, field.name+" is too long", http.StatusBadRequest)
+			return
+		}
+		updateFields[field.name] = value
+	}
+
+	if body.AvatarURL != nil && updateFields["avatar_url"] != "" {
+		if err := validateAvatarURL(updateFields["avatar_url"].(string)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if len(updateFields) == 0 {
+		http.Error(w, "No fields to update", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	err := db.UserCollection.FindOneAndUpdate(context.Background(),
+		bson.M{"_id": principal.UserID},
+		bson.M{"$set": updateFields},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "User not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to update profile", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	user.Role = user.EffectiveRole()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// ChangePassword replaces the caller's password after checking the current one. Accounts
+// created through an identity provider have none, so they set their first password after a
+// fresh login or with a two-factor code instead. Every other session is signed out.
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+		Code            string `json:"code"` // for accounts without a password
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	user, err := findUser(principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return
+	}
+	if user.Password == "" {
+		if !confirmPasswordless(w, principal, user, body.Code) {
+			return
+		}
+	} else if !utils.VerifyPassword(user.Password, body.CurrentPassword) {
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+	if err := utils.ValidatePassword(body.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hash, err := utils.HashPassword(body.NewPassword)
+	if err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+	_, err = db.UserCollection.UpdateOne(context.Background(), bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"password": hash}})
+	if err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := utils.RevokeOtherFamilies(context.Background(), user.ID, principal.SessionID, "password change"); err != nil {
+		http.Error(w, "Failed to revoke other sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteMe deletes the caller's account after checking their password, or for an account
+// without one a fresh login or a two-factor code. Their trips and the
+// comments on them are deleted, and comments they left on other people's trips are anonymized,
+// all in one transaction. While they are named on expenses of other people's trips the account
+// stays, since the others' balances depend on them.
+func DeleteMe(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		Password string `json:"password"`
+		Code     string `json:"code"` // for accounts without a password
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	user, err := findUser(principal.UserID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return
+	}
+	if user.Password == "" {
+		if !confirmPasswordless(w, principal, user, body.Code) {
+			return
+		}
+	} else if !utils.VerifyPassword(user.Password, body.Password) {
+		http.Error(w, "Password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	if err := deleteUserCascade(context.Background(), user.ID); err != nil {
+		if errors.Is(err, errOpenExpenses) {
+			http.Error(w, "You are named on expenses of other members' trips; settle up and have them removed first", http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// errOpenExpenses is returned by deleteUserCascade for a user named on expenses of trips
+// they don't own
+var errOpenExpenses = errors.New("user is named on expenses of other trips")
+
+// deleteUserCascade removes a user together with the data that depends on them. Their
+// sessions are revoked in the same transaction, so the account is never gone while tokens
+// issued to it still work. Expenses have no record of being paid back, so a user who paid for
+// or shared an expense on someone else's trip can't be removed from it without changing the
+// other members' balances; deleteUserCascade refuses with errOpenExpenses instead.
+func deleteUserCascade(ctx context.Context, userID primitive.ObjectID) error {
+	session, err := db.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		tripIDs, err := db.TripCollection.Distinct(sc, "_id", bson.M{"user_id": userID})
+		if err != nil {
+			return nil, err
+		}
+
+		open, err := db.ExpenseCollection.CountDocuments(sc, bson.M{
+			"trip_id": bson.M{"$nin": append(bson.A{}, tripIDs...)},
+			"$or": bson.A{
+				bson.M{"paid_by": userID},
+				bson.M{"participants": userID},
+			},
+		}, options.Count().SetLimit(1))
+		if err != nil {
+			return nil, err
+		}
+		if open > 0 {
+			return nil, errOpenExpenses
+		}
+
+		if err := utils.RevokeAllForUser(sc, userID, "account deleted"); err != nil {
+			return nil, err
+		}
+
+		// Comments, invites, share links, the itinerary and expenses on the user's trips go
+		// with the trips
+		if len(tripIDs) > 0 {
+			if err := deleteTripDependents(sc, tripIDs); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := db.TripCollection.DeleteMany(sc, bson.M{"user_id": userID}); err != nil {
+			return nil, err
+		}
+
+		// The user loses their place on trips they were added to
+		if _, err := db.TripCollection.UpdateMany(sc,
+			bson.M{"members.user_id": userID},
+			bson.M{"$pull": bson.M{"members": bson.M{"user_id": userID}}},
+		); err != nil {
+			return nil, err
+		}
+
+		// Comments on other people's trips stay, but no longer point at the user
+		if _, err := db.CommentCollection.UpdateMany(sc,
+			bson.M{"user_id": userID},
+			bson.M{"$set": bson.M{"user_id": primitive.NilObjectID}},
+		); err != nil {
+			return nil, err
+		}
+
+		// API keys, sessions and tokens go with the user; the revocations above stay
+		for _, collection := range userCredentialCollections() {
+			if _, err := collection.DeleteMany(sc, bson.M{"user_id": userID}); err != nil {
+				return nil, err
+			}
+		}
+
+		result, err := db.UserCollection.DeleteOne(sc, bson.M{"_id": userID})
+		if err != nil {
+			return nil, err
+		}
+		if result.DeletedCount == 0 {
+			return nil, mongo.ErrNoDocuments
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// confirmPasswordless checks the caller holds an account that has no password, before a change
+// that would otherwise ask for it. A current two-factor code does when the account has one;
+// otherwise the caller must have signed in within recentLoginWindow, which for these accounts
+// means through their identity provider. It writes the error response and returns false when
+// neither holds.
+func confirmPasswordless(w http.ResponseWriter, principal *middleware.Principal, user *models.User, code string) bool {
+	if code != "" && user.MFAEnabled {
+		ok, err := verifySecondFactor(user, code, "")
+		if err != nil {
+			http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+			return false
+		}
+		if !ok {
+			http.Error(w, "Invalid code", http.StatusForbidden)
+			return false
+		}
+		return true
+	}
+
+	recent, err := signedInRecently(principal)
+	if err != nil {
+		http.Error(w, "Failed to check session", http.StatusInternalServerError)
+		return false
+	}
+	if !recent {
+		http.Error(w, "Sign in again to confirm this change", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// signedInRecently reports whether the caller's session started within recentLoginWindow.
+// Refreshing tokens keeps a session going but doesn't make it new.
+func signedInRecently(principal *middleware.Principal) (bool, error) {
+	if principal.SessionID == "" {
+		return false, nil
+	}
+	var session models.Session
+	err := db.SessionCollection.FindOne(context.Background(), bson.M{
+		"_id":           principal.SessionID,
+		"user_id":       principal.UserID,
+		"terminated_at": bson.M{"$exists": false},
+	}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return time.Since(session.CreatedAt) < recentLoginWindow, nil
+}
+
+// findUser loads a user by ID with the role filled in
+func findUser(userID primitive.ObjectID) (*models.User, error) {
+	var user models.User
+	err := db.UserCollection.FindOne(context.Background(), bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+	user.Role = user.EffectiveRole()
+	return &user, nil
+}
+
+// validateAvatarURL only accepts absolute http(s) URLs
+func validateAvatarURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("avatar_url must be an absolute http or https URL")
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"trip-planner/db"
+	"trip-planner/limiter"
+)
+
+// Login attempt limits. Accounts lock quickly since real users rarely mistype five times in a
+// row; IPs get more room because several people can share one address.
+var (
+	accountLimiter = &limiter.Limiter{
+		Store:        limiter.NewMemoryStore(),
+		Prefix:       "account:",
+		FreeAttempts: 5,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+	}
+	ipLimiter = &limiter.Limiter{
+		Store:        accountLimiter.Store,
+		Prefix:       "ip:",
+		FreeAttempts: 20,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		Window:       time.Hour,
+	}
+)
+
+// InitLoginLimiter picks where login failures are counted from LOGIN_LIMITER_STORE:
+// "memory" (default, single instance) or "mongo" (shared across instances). Call after db.InitDB.
+func InitLoginLimiter() error {
+	var store limiter.Store
+	switch strings.ToLower(os.Getenv("LOGIN_LIMITER_STORE")) {
+	case "", "memory":
+		store = limiter.NewMemoryStore()
+	case "mongo":
+		store = limiter.NewMongoStore(db.LoginAttemptCollection)
+	default:
+		return fmt.Errorf("unsupported LOGIN_LIMITER_STORE %q", os.Getenv("LOGIN_LIMITER_STORE"))
+	}
+	accountLimiter.Store = store
+	ipLimiter.Store = store
+	return nil
+}
+
+// loginLockout returns how long the IP or account must still wait before trying again
+func loginLockout(ctx context.Context, ip, account string) time.Duration {
+	var wait time.Duration
+	for _, check := range []struct {
+		limiter *limiter.Limiter
+		key     string
+	}{{ipLimiter, ip}, {accountLimiter, account}} {
+		remaining, err := check.limiter.Check(ctx, check.key)
+		if err != nil {
+			log.Printf("Failed to check login limiter: %v", err)
+			continue
+		}
+		if remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait
+}
+
+// recordLoginFailure counts a failed login for the IP and account and returns any lockout triggered
+func recordLoginFailure(ctx context.Context, ip, account string) time.Duration {
+	var wait time.Duration
+	for _, fail := range []struct {
+		limiter *limiter.Limiter
+		key     string
+	}{{ipLimiter, ip}, {accountLimiter, account}} {
+		delay, err := fail.limiter.Fail(ctx, fail.key)
+		if err != nil {
+			log.Printf("Failed to record login failure: %v", err)
+			continue
+		}
+		if delay > wait {
+			wait = delay
+		}
+	}
+	return wait
+}
+
+// recordLoginSuccess clears the account's failures. The IP counter is left to decay on its own
+// so one valid account can't be used to reset it while guessing others.
+func recordLoginSuccess(ctx context.Context, account string) {
+	if err := accountLimiter.Succeed(ctx, account); err != nil {
+		log.Printf("Failed to reset login limiter: %v", err)
+	}
+}
+
+// setRetryAfter writes the Retry-After header in whole seconds, rounding up
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
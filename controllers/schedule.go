@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"errors"
+	"net/url"
+	"time"
+	"trip-planner/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// checkTripSchedule validates a trip's dates and derives its start and end instants. Trips past
+// the draft stage must have dates, since the status job moves them along by the clock.
+func checkTripSchedule(trip *models.Trip) error {
+	if err := trip.ResolveSchedule(); err != nil {
+		return err
+	}
+	switch trip.Status {
+	case models.TripStatusPlanned, models.TripStatusInProgress, models.TripStatusCompleted:
+		if trip.StartsAt == nil {
+			return errors.New("A " + trip.Status + " trip needs start_date and end_date")
+		}
+	}
+	return nil
+}
+
+// hasScheduleChange reports whether an update touches the trip's dates, time zone or status
+func hasScheduleChange(input *models.Trip) bool {
+	return input.StartDate != "" || input.EndDate != "" || input.TimeZone != "" || input.Status != ""
+}
+
+// scheduleUpdate merges the dates, time zone and status of an update into the current trip,
+// checks the result and returns the fields to set
+func scheduleUpdate(current, input *models.Trip) (bson.M, error) {
+	next := *current
+	if next.Status == "" {
+		next.Status = models.TripStatusDraft
+	}
+	if input.StartDate != "" {
+		next.StartDate = input.StartDate
+	}
+	if input.EndDate != "" {
+		next.EndDate = input.EndDate
+	}
+	if input.TimeZone != "" {
+		next.TimeZone = input.TimeZone
+	}
+	if input.Status != "" && input.Status != next.Status {
+		if !models.ValidTripStatus(input.Status) {
+			return nil, errors.New("Status must be one of draft, planned, in-progress, completed, cancelled")
+		}
+		if !models.CanTransitionTrip(next.Status, input.Status) {
+			return nil, errors.New("A trip can't move from " + next.Status + " to " + input.Status)
+		}
+		next.Status = input.Status
+	}
+	if err := checkTripSchedule(&next); err != nil {
+		return nil, err
+	}
+
+	fields := bson.M{"status": next.Status}
+	if next.StartsAt != nil {
+		fields["start_date"] = next.StartDate
+		fields["end_date"] = next.EndDate
+		fields["time_zone"] = next.TimeZone
+		fields["starts_at"] = next.StartsAt
+		fields["ends_at"] = next.EndsAt
+	}
+	return fields, nil
+}
+
+// addScheduleFilters narrows a trip query by ?status= and by a date range: ?from= keeps trips
+// ending on or after that date, ?to= trips starting on or before it. Dates are YYYY-MM-DD and
+// compared with each trip's local dates, so undated trips drop out when either is given.
+func addScheduleFilters(filter bson.M, query url.Values) error {
+	if status := query.Get("status"); status != "" {
+		if !models.ValidTripStatus(status) {
+			return errors.New("Status must be one of draft, planned, in-progress, completed, cancelled")
+		}
+		if status == models.TripStatusDraft {
+			// Trips from before statuses existed are drafts too
+			filter["status"] = bson.M{"$in": bson.A{status, nil}}
+		} else {
+			filter["status"] = status
+		}
+	}
+	if from := query.Get("from"); from != "" {
+		if _, err := time.Parse(models.TripDateLayout, from); err != nil {
+			return errors.New("from must be YYYY-MM-DD")
+		}
+		filter["end_date"] = bson.M{"$gte": from}
+	}
+	if to := query.Get("to"); to != "" {
+		if _, err := time.Parse(models.TripDateLayout, to); err != nil {
+			return errors.New("to must be YYYY-MM-DD")
+		}
+		filter["start_date"] = bson.M{"$lte": to}
+	}
+	return nil
+}
+
+// storedValue matches a trip field as loaded, where an empty string means the field is unset
+func storedValue(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+	"trip-planner/db"
+	"trip-planner/middleware"
+	"trip-planner/models"
+	"trip-planner/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListSessions returns the caller's active sessions, most recently used first
+func ListSessions(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	cursor, err := db.SessionCollection.Find(context.Background(),
+		bson.M{
+			"user_id":       principal.UserID,
+			"terminated_at": bson.M{"$exists": false},
+			"expires_at":    bson.M{"$gt": time.Now()},
+		},
+		options.Find().SetSort(bson.M{"last_seen_at": -1}),
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch sessions", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	sessions := []models.Session{}
+	if err := cursor.All(context.Background(), &sessions); err != nil {
+		http.Error(w, "Error while fetching sessions", http.StatusInternalServerError)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == principal.SessionID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// TerminateSession signs one of the caller's sessions out. Its tokens stop working immediately.
+func TerminateSession(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	sessionID := mux.Vars(r)["id"]
+
+	var session models.Session
+	err := db.SessionCollection.FindOne(context.Background(), bson.M{
+		"_id":           sessionID,
+		"user_id":       principal.UserID,
+		"terminated_at": bson.M{"$exists": false},
+	}).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Session not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve session", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := utils.RevokeFamily(context.Background(), principal.UserID, session.ID, "session terminated"); err != nil {
+		http.Error(w, "Failed to terminate session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
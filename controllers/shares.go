@@ -0,0 +1,268 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"trip-planner/db"
+	"trip-planner/middleware"
+	"trip-planner/models"
+	"trip-planner/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// sharePasswordHeader carries the password of a protected share link, so it stays out of URLs and logs
+const sharePasswordHeader = "X-Share-Password"
+
+// CreateShareLink mints a read-only share URL for the trip. Only owners may share. The body may
+// set expires_in_hours (default: no expiry) and a password the viewer must send along.
+func CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+
+	var body struct {
+		ExpiresInHours int    `json:"expires_in_hours"`
+		Password       string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if body.ExpiresInHours < 0 {
+		http.Error(w, "expires_in_hours can't be negative", http.StatusBadRequest)
+		return
+	}
+	if len(body.Password) > utils.MaxPasswordLength {
+		http.Error(w, fmt.Sprintf("password must be at most %d bytes", utils.MaxPasswordLength), http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleOwner)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	link := models.ShareLink{
+		ID:        primitive.NewObjectID(),
+		TripID:    trip.ID,
+		CreatedBy: principal.UserID,
+		CreatedAt: now,
+	}
+	ttl := time.Duration(body.ExpiresInHours) * time.Hour
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		link.ExpiresAt = &expiresAt
+	}
+	if body.Password != "" {
+		hash, err := utils.HashPassword(body.Password)
+		if err != nil {
+			http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+			return
+		}
+		link.PasswordHash = hash
+		link.HasPassword = true
+	}
+
+	token, err := utils.GenerateShareToken(link.ID.Hex(), ttl)
+	if err != nil {
+		http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+		return
+	}
+	link.TokenHash = utils.HashToken(token)
+	if _, err := db.ShareLinkCollection.InsertOne(context.Background(), link); err != nil {
+		http.Error(w, "Failed to create share link", http.StatusInternalServerError)
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "trip.share_create",
+		TargetType: "trip",
+		TargetID:   trip.ID,
+		Details:    "share link " + link.ID.Hex(),
+	})
+
+	// The token is only ever shown here
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		models.ShareLink
+		Token string `json:"token"`
+		URL   string `json:"url"`
+	}{link, token, appLink("/shared/trip", token)})
+}
+
+// ListShareLinks returns the trip's share links that still work. Only owners may list them.
+func ListShareLinks(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleOwner)
+	if !ok {
+		return
+	}
+
+	cursor, err := db.ShareLinkCollection.Find(context.Background(),
+		bson.M{
+			"trip_id":    trip.ID,
+			"revoked_at": bson.M{"$exists": false},
+			"$or": bson.A{
+				bson.M{"expires_at": bson.M{"$exists": false}},
+				bson.M{"expires_at": bson.M{"$gt": time.Now()}},
+			},
+		},
+		options.Find().SetSort(bson.M{"created_at": -1}),
+	)
+	if err != nil {
+		http.Error(w, "Failed to fetch share links", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	links := []models.ShareLink{}
+	if err := cursor.All(context.Background(), &links); err != nil {
+		http.Error(w, "Error while fetching share links", http.StatusInternalServerError)
+		return
+	}
+	for i := range links {
+		links[i].HasPassword = links[i].PasswordHash != ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+// RevokeShareLink stops a share link from working
+func RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	principal := middleware.CurrentPrincipal(r)
+	shareID, err := primitive.ObjectIDFromHex(mux.Vars(r)["share_id"])
+	if err != nil {
+		http.Error(w, "Invalid share link ID format", http.StatusBadRequest)
+		return
+	}
+
+	trip, ok := loadTripFor(w, r, principal, models.TripRoleOwner)
+	if !ok {
+		return
+	}
+
+	result, err := db.ShareLinkCollection.UpdateOne(context.Background(),
+		bson.M{"_id": shareID, "trip_id": trip.ID, "revoked_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revoked_at": time.Now()}},
+	)
+	if err != nil {
+		http.Error(w, "Failed to revoke share link", http.StatusInternalServerError)
+		return
+	}
+	if result.MatchedCount == 0 {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+
+	recordAudit(r, models.AuditEntry{
+		ActorID:    principal.UserID,
+		Action:     "trip.share_revoke",
+		TargetType: "trip",
+		TargetID:   trip.ID,
+		Details:    "share link " + shareID.Hex(),
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSharedTrip serves the read-only view of a shared trip and its comments to whoever holds
+// the link's ?token=. Password-protected links also need the X-Share-Password header; wrong
+// passwords count against the same lockout as logins.
+func GetSharedTrip(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	claims, err := utils.ValidateShareToken(token)
+	if err != nil {
+		http.Error(w, "Invalid or expired share link", http.StatusNotFound)
+		return
+	}
+	shareID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		http.Error(w, "Invalid or expired share link", http.StatusNotFound)
+		return
+	}
+
+	var link models.ShareLink
+	err = db.ShareLinkCollection.FindOne(context.Background(), bson.M{
+		"_id":        shareID,
+		"token_hash": utils.HashToken(token),
+		"revoked_at": bson.M{"$exists": false},
+	}).Decode(&link)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Invalid or expired share link", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to open share link", http.StatusInternalServerError)
+		}
+		return
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		http.Error(w, "Invalid or expired share link", http.StatusNotFound)
+		return
+	}
+
+	if link.PasswordHash != "" {
+		// The link is shared by everyone holding it, so a lockout on the link alone would let one
+		// guesser lock them all out. Failures count per IP and per IP on this link.
+		ip := utils.ClientIP(r)
+		account := "share:" + link.ID.Hex() + ":" + ip
+		if wait := loginLockout(context.Background(), ip, account); wait > 0 {
+			setRetryAfter(w, wait)
+			http.Error(w, "Too many attempts, try again later", http.StatusTooManyRequests)
+			return
+		}
+		if !utils.VerifyPassword(link.PasswordHash, r.Header.Get(sharePasswordHeader)) {
+			if wait := recordLoginFailure(context.Background(), ip, account); wait > 0 {
+				setRetryAfter(w, wait)
+			}
+			http.Error(w, "Password required", http.StatusUnauthorized)
+			return
+		}
+		recordLoginSuccess(context.Background(), account)
+	}
+
+	var trip models.Trip
+	err = db.TripCollection.FindOne(context.Background(), bson.M{"_id": link.TripID}).Decode(&trip)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			http.Error(w, "Trip not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to retrieve trip", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	cursor, err := db.CommentCollection.Find(context.Background(), bson.M{"trip_id": trip.ID})
+	if err != nil {
+		http.Error(w, "Failed to retrieve comments", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.Background())
+	comments := []models.Comment{}
+	if err := cursor.All(context.Background(), &comments); err != nil {
+		http.Error(w, "Failed to retrieve comments", http.StatusInternalServerError)
+		return
+	}
+
+	// Best effort, the owner only sees it as a hint of whether the link is in use
+	db.ShareLinkCollection.UpdateOne(context.Background(),
+		bson.M{"_id": link.ID},
+		bson.M{"$set": bson.M{"last_used_at": time.Now()}},
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(struct {
+		Trip     models.Trip      `json:"trip"`
+		Comments []models.Comment `json:"comments"`
+	}{trip.ReadOnlyView(), comments})
+}
@@ -3,11 +3,13 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"trip-planner/db"
+	"trip-planner/middleware"
 	"trip-planner/models"
-	"trip-planner/utils"
+	"trip-planner/policy"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,6 +17,10 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Attractions are only read from trips created before itineraries, until MigrateAttractions
+// turns them into stops
+const attractionsDeprecatedMessage = "Attractions are no longer stored on the trip; add them as stops"
+
 func CreateTrip(w http.ResponseWriter, r *http.Request) {
     var trip models.Trip
     err := json.NewDecoder(r.Body).Decode(&trip)
@@ -24,14 +30,45 @@ func CreateTrip(w http.ResponseWriter, r *http.Request) {
     }
 
     // Get the user ID from the token
-    userID, err := getUserIDFromToken(r)
+    userID, err := getUserIDFromContext(r)
     if err != nil {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        middleware.Unauthorized(w)
+        return
+    }
+
+    if trip.Attractions != "" {
+        http.Error(w, attractionsDeprecatedMessage, http.StatusBadRequest)
+        return
+    }
+
+    // New trips are private unless the creator says otherwise
+    if trip.Visibility == "" {
+        trip.Visibility = models.TripVisibilityPrivate
+    }
+    if !models.ValidTripVisibility(trip.Visibility) {
+        http.Error(w, "Visibility must be one of private, unlisted, public", http.StatusBadRequest)
+        return
+    }
+
+    // New trips start as drafts, or as planned when they already have dates
+    if trip.Status == "" {
+        trip.Status = models.TripStatusDraft
+    }
+    if trip.Status != models.TripStatusDraft && trip.Status != models.TripStatusPlanned {
+        http.Error(w, "New trips must be draft or planned", http.StatusBadRequest)
+        return
+    }
+    if err := checkTripSchedule(&trip); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
 
-    // Set the user_id for the trip
+    // Set the user_id for the trip; collaborators are added through the members endpoints
+    // and the budget through the budget endpoint
     trip.UserID = userID
+    trip.Members = nil
+    trip.Currency = ""
+    trip.Budget = nil
     trip.ID = primitive.NewObjectID() // Ensure the ID is generated
 
     // Insert trip into the database
@@ -41,18 +78,41 @@ func CreateTrip(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    // Warn about members who are already travelling at the same time
+    attachTripConflicts(middleware.CurrentPrincipal(r), &trip)
+
     w.WriteHeader(http.StatusCreated)
     json.NewEncoder(w).Encode(trip)
 }
 
-func getUserIDFromToken(r *http.Request) (primitive.ObjectID, error) {
-    tokenString := r.Header.Get("Authorization")
-    claims, err := utils.ValidateJWT(tokenString) // Validate the JWT token
-    if err != nil {
-        return primitive.NilObjectID, err
+// getUserIDFromContext returns the caller injected by the authentication middleware
+func getUserIDFromContext(r *http.Request) (primitive.ObjectID, error) {
+    principal := middleware.CurrentPrincipal(r)
+    if principal == nil {
+        return primitive.NilObjectID, errors.New("no authenticated user")
+    }
+    return principal.UserID, nil
+}
+
+// tripAccessFilter limits a trip lookup to trips where the caller holds at least minRole,
+// unless their role may manage any trip
+func tripAccessFilter(tripID primitive.ObjectID, principal *middleware.Principal, minRole string) bson.M {
+    filter := bson.M{"_id": tripID}
+    if !policy.Can(principal.Role, policy.ManageAnyTrip) {
+        filter["$or"] = tripMemberClauses(principal.UserID, minRole)
+    }
+    return filter
+}
+
+// tripMemberClauses matches trips the user created or is a member of with at least minRole
+func tripMemberClauses(userID primitive.ObjectID, minRole string) bson.A {
+    return bson.A{
+        bson.M{"user_id": userID},
+        bson.M{"members": bson.M{"$elemMatch": bson.M{
+            "user_id": userID,
+            "role":    bson.M{"$in": policy.TripRolesFor(minRole)},
+        }}},
     }
-    userID, err := primitive.ObjectIDFromHex(claims.UserID) // Extract the ObjectID from the claims
-    return userID, err
 }
 
 
@@ -65,14 +125,14 @@ func GetTripByID(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    userID, err := getUserIDFromToken(r)
-    if err != nil {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+    principal := middleware.CurrentPrincipal(r)
+    if principal == nil {
+        middleware.Unauthorized(w)
         return
     }
 
     var trip models.Trip
-    err = db.TripCollection.FindOne(context.Background(), bson.M{"_id": tripObjID, "user_id": userID}).Decode(&trip)
+    err = db.TripCollection.FindOne(context.Background(), bson.M{"_id": tripObjID}).Decode(&trip)
     if err != nil {
         if err == mongo.ErrNoDocuments {
             http.Error(w, "Trip not found", http.StatusNotFound)
@@ -82,27 +142,33 @@ func GetTripByID(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    json.NewEncoder(w).Encode(trip)
+    // Members see the whole trip; anyone else only a read-only view of public or unlisted trips
+    if policy.CanAccessTrip(principal.UserID, principal.Role, &trip, models.TripRoleViewer) {
+        json.NewEncoder(w).Encode(trip)
+        return
+    }
+    if !trip.OpenToNonMembers() {
+        http.Error(w, "Trip not found", http.StatusNotFound)
+        return
+    }
+    json.NewEncoder(w).Encode(trip.ReadOnlyView())
 }
 
 func GetTrips(w http.ResponseWriter, r *http.Request) {
-	// Extract user from JWT token
-	tokenString := r.Header.Get("Authorization")
-	claims, err := utils.ValidateJWT(tokenString) // Validate JWT and extract claims
+	// Get the caller from the request context
+	userID, err := getUserIDFromContext(r)
 	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		middleware.Unauthorized(w)
 		return
 	}
 
-	// Convert the claims.UserID string to a primitive.ObjectID
-	userID, err := primitive.ObjectIDFromHex(claims.UserID)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusInternalServerError)
+	// Fetch every trip the logged-in user created or was added to, narrowed by ?status=, ?from= and ?to=
+	filter := bson.M{"$or": tripMemberClauses(userID, models.TripRoleViewer)}
+	if err := addScheduleFilters(filter, r.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	// Fetch all trips for the logged-in user
-	cursor, err := db.TripCollection.Find(context.Background(), bson.M{"user_id": userID})
+	cursor, err := db.TripCollection.Find(context.Background(), filter)
 	if err != nil {
 		http.Error(w, "Failed to fetch trips", http.StatusInternalServerError)
 		return
@@ -151,18 +217,32 @@ func UpdateTrip(w http.ResponseWriter, r *http.Request) {
     // Log the incoming trip object for debugging purposes
     log.Printf("Received trip data: %+v", trip)
 
-    // Extract user ID from the JWT token
-    userID, err := getUserIDFromToken(r)
-    if err != nil {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+    if trip.Attractions != "" {
+        http.Error(w, attractionsDeprecatedMessage, http.StatusBadRequest)
+        return
+    }
+
+    // Get the caller from the request context
+    principal := middleware.CurrentPrincipal(r)
+    if principal == nil {
+        middleware.Unauthorized(w)
         return
     }
 
-    // Log the extracted userID for debugging purposes
-    log.Printf("UserID from token: %v", userID)
+    // Log the caller for debugging purposes
+    log.Printf("UserID from token: %v", principal.UserID)
 
-    // Ensure the trip belongs to the user and user has authorization to update it
-    filter := bson.M{"_id": tripObjID, "user_id": userID}
+    // Owners and editors may edit, but only owners may change who can see the trip,
+    // unless the caller's role may manage any trip
+    minRole := models.TripRoleEditor
+    if trip.Visibility != "" {
+        if !models.ValidTripVisibility(trip.Visibility) {
+            http.Error(w, "Visibility must be one of private, unlisted, public", http.StatusBadRequest)
+            return
+        }
+        minRole = models.TripRoleOwner
+    }
+    filter := tripAccessFilter(tripObjID, principal, minRole)
 
     // Log the filter for debugging purposes
     log.Printf("Filter: %+v", filter)
@@ -181,8 +261,39 @@ func UpdateTrip(w http.ResponseWriter, r *http.Request) {
     if trip.Description != "" {
         updateFields["description"] = trip.Description
     }
-    if trip.Attractions != "" {
-        updateFields["attractions"] = trip.Attractions
+    if trip.Visibility != "" {
+        updateFields["visibility"] = trip.Visibility
+    }
+
+    // Dates and status are checked against the trip as it is now, and only written if they
+    // are still the same then
+    updateFilter := bson.M{}
+    for field, value := range filter {
+        updateFilter[field] = value
+    }
+    if hasScheduleChange(&trip) {
+        var currentTrip models.Trip
+        err = db.TripCollection.FindOne(context.Background(), filter).Decode(&currentTrip)
+        if err != nil {
+            if err == mongo.ErrNoDocuments {
+                http.Error(w, "Trip not found or you do not have permission to edit", http.StatusNotFound)
+            } else {
+                http.Error(w, "Failed to update trip", http.StatusInternalServerError)
+            }
+            return
+        }
+        scheduleFields, err := scheduleUpdate(&currentTrip, &trip)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+        for field, value := range scheduleFields {
+            updateFields[field] = value
+        }
+        updateFilter["status"] = storedValue(currentTrip.Status)
+        updateFilter["start_date"] = storedValue(currentTrip.StartDate)
+        updateFilter["end_date"] = storedValue(currentTrip.EndDate)
+        updateFilter["time_zone"] = storedValue(currentTrip.TimeZone)
     }
 
     // If no fields were specified for update, return an error
@@ -193,11 +304,30 @@ func UpdateTrip(w http.ResponseWriter, r *http.Request) {
 
     // Perform the update operation, setting only the specified fields
     update := bson.M{"$set": updateFields}
-    result := db.TripCollection.FindOneAndUpdate(context.Background(), filter, update)
-
-    // Log the result of the update for debugging purposes
-    if result.Err() != nil {
-        if result.Err() == mongo.ErrNoDocuments {
+    var previousTrip models.Trip
+    err = db.TripCollection.FindOneAndUpdate(context.Background(), updateFilter, update).Decode(&previousTrip)
+    if err == mongo.ErrNoDocuments && len(updateFilter) > len(filter) {
+        // Tell a schedule that changed underneath apart from a trip that's gone
+        count, countErr := db.TripCollection.CountDocuments(context.Background(), filter)
+        if countErr != nil {
+            http.Error(w, "Failed to update trip", http.StatusInternalServerError)
+            return
+        }
+        if count > 0 {
+            http.Error(w, "The trip's dates or status changed while you were editing it; reload and try again", http.StatusConflict)
+            return
+        }
+    }
+    if err != nil {
+        if err == mongo.ErrNoDocuments {
+            recordAudit(r, models.AuditEntry{
+                ActorID:    principal.UserID,
+                Action:     "trip.update",
+                TargetType: "trip",
+                TargetID:   tripObjID,
+                Outcome:    models.AuditFailure,
+                Details:    "not found or not permitted",
+            })
             http.Error(w, "Trip not found or you do not have permission to edit", http.StatusNotFound)
         } else {
             http.Error(w, "Failed to update trip", http.StatusInternalServerError)
@@ -205,6 +335,14 @@ func UpdateTrip(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    recordAudit(r, models.AuditEntry{
+        ActorID:    principal.UserID,
+        Action:     "trip.update",
+        TargetType: "trip",
+        TargetID:   tripObjID,
+        Details:    "owner " + previousTrip.UserID.Hex(),
+    })
+
     // Prepare the updated trip data to send as response (preserve ObjectID)
     // Re-fetch the trip document to send the updated version
     var updatedTrip models.Trip
@@ -214,6 +352,9 @@ func UpdateTrip(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    // Warn about members who are already travelling at the same time
+    attachTripConflicts(principal, &updatedTrip)
+
     // Send the updated trip as a response, preserving the ObjectID
     w.WriteHeader(http.StatusOK)
     json.NewEncoder(w).Encode(updatedTrip)
@@ -229,24 +370,39 @@ func DeleteTrip(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    userID, err := getUserIDFromToken(r)
-    if err != nil {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+    principal := middleware.CurrentPrincipal(r)
+    if principal == nil {
+        middleware.Unauthorized(w)
         return
     }
 
-    // Delete trip from database ensuring it's the user's trip
-    filter := bson.M{"_id": tripObjID, "user_id": userID}
-    result, err := db.TripCollection.DeleteOne(context.Background(), filter)
+    // Only owners may delete the trip, unless the caller's role may manage any trip.
+    // Its comments, invites and share links go with it.
+    deletedTrip, err := deleteTripCascade(context.Background(), tripAccessFilter(tripObjID, principal, models.TripRoleOwner))
     if err != nil {
-        http.Error(w, "Failed to delete trip", http.StatusInternalServerError)
+        if err == mongo.ErrNoDocuments {
+            recordAudit(r, models.AuditEntry{
+                ActorID:    principal.UserID,
+                Action:     "trip.delete",
+                TargetType: "trip",
+                TargetID:   tripObjID,
+                Outcome:    models.AuditFailure,
+                Details:    "not found or not permitted",
+            })
+            http.Error(w, "Trip not found or you do not have permission to delete", http.StatusNotFound)
+        } else {
+            http.Error(w, "Failed to delete trip", http.StatusInternalServerError)
+        }
         return
     }
 
-    if result.DeletedCount == 0 {
-        http.Error(w, "Trip not found or you do not have permission to delete", http.StatusNotFound)
-        return
-    }
+    recordAudit(r, models.AuditEntry{
+        ActorID:    principal.UserID,
+        Action:     "trip.delete",
+        TargetType: "trip",
+        TargetID:   tripObjID,
+        Details:    "owner " + deletedTrip.UserID.Hex(),
+    })
 
     w.WriteHeader(http.StatusOK)
     w.Write([]byte("Trip deleted successfully"))
@@ -2,16 +2,39 @@ package db
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// CaseInsensitive is the collation of the unique user indexes. Queries on email or
+// username must use it too, both to match case-insensitively and to hit the index.
+var CaseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+// Client is the connected MongoDB client, used to start sessions for transactions
+var Client *mongo.Client
+
 var UserCollection *mongo.Collection
 var TripCollection *mongo.Collection
 var CommentCollection *mongo.Collection
+var RefreshTokenCollection *mongo.Collection
+var RevokedTokenCollection *mongo.Collection
+var AuditCollection *mongo.Collection
+var UserTokenCollection *mongo.Collection
+var LoginAttemptCollection *mongo.Collection
+var OIDCStateCollection *mongo.Collection
+var APIKeyCollection *mongo.Collection
+var SessionCollection *mongo.Collection
+var TripInviteCollection *mongo.Collection
+var ShareLinkCollection *mongo.Collection
+var TripDayCollection *mongo.Collection
+var StopCollection *mongo.Collection
+var ExpenseCollection *mongo.Collection
+var ExchangeRateCollection *mongo.Collection
 
 // InitDB initializes MongoDB connection
 func InitDB() error {
@@ -39,11 +62,191 @@ func InitDB() error {
 		return err
 	}
 
+	Client = client
+
 	// Initialize the collections
 	UserCollection = client.Database("trip-planner").Collection("users")
 	TripCollection = client.Database("trip-planner").Collection("trips")
 	CommentCollection = client.Database("trip-planner").Collection("comments")
+	RefreshTokenCollection = client.Database("trip-planner").Collection("refresh_tokens")
+	RevokedTokenCollection = client.Database("trip-planner").Collection("revoked_tokens")
+	AuditCollection = client.Database("trip-planner").Collection("audit_log")
+	UserTokenCollection = client.Database("trip-planner").Collection("user_tokens")
+	LoginAttemptCollection = client.Database("trip-planner").Collection("login_attempts")
+	OIDCStateCollection = client.Database("trip-planner").Collection("oidc_states")
+	APIKeyCollection = client.Database("trip-planner").Collection("api_keys")
+	SessionCollection = client.Database("trip-planner").Collection("sessions")
+	TripInviteCollection = client.Database("trip-planner").Collection("trip_invites")
+	ShareLinkCollection = client.Database("trip-planner").Collection("share_links")
+	TripDayCollection = client.Database("trip-planner").Collection("trip_days")
+	StopCollection = client.Database("trip-planner").Collection("trip_stops")
+	ExpenseCollection = client.Database("trip-planner").Collection("trip_expenses")
+	ExchangeRateCollection = client.Database("trip-planner").Collection("exchange_rates")
+
+	// Make sure the indexes the application relies on exist
+	err = ensureIndexes(ctx)
+	if err != nil {
+		log.Printf("Error creating MongoDB indexes: %v", err)
+		return err
+	}
 
 	log.Println("Connected to MongoDB successfully!")
 	return nil
 }
+
+// ensureIndexes creates the indexes used for lookups and TTL expiry
+func ensureIndexes(ctx context.Context) error {
+	_, err := UserCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("email_unique").SetUnique(true).SetCollation(CaseInsensitive),
+		},
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetName("username_unique").SetUnique(true).SetCollation(CaseInsensitive),
+		},
+		{
+			// Partial so the many users without external identities don't collide on null
+			Keys: bson.D{{Key: "identities.issuer", Value: 1}, {Key: "identities.subject", Value: 1}},
+			Options: options.Index().SetName("identity_unique").SetUnique(true).
+				SetPartialFilterExpression(bson.M{"identities.subject": bson.M{"$exists": true}}),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating unique user indexes (existing duplicate emails or usernames must be resolved first): %w", err)
+	}
+
+	_, err = RefreshTokenCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "family", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = RevokedTokenCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = UserTokenCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = LoginAttemptCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = OIDCStateCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "state_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = APIKeyCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = SessionCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_seen_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Trips are listed by creator, by member and in the public feed, and the status job
+	// looks them up by status and date
+	_, err = TripCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "members.user_id", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "starts_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "ends_at", Value: 1}}},
+		// The feed filters case-insensitively, so its index needs the same collation
+		{Keys: bson.D{{Key: "visibility", Value: 1}, {Key: "_id", Value: -1}}, Options: options.Index().SetCollation(CaseInsensitive)},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Invites are looked up by token hash and listed per trip; expired ones are cleaned up
+	_, err = TripInviteCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "trip_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Share links are looked up by token hash and listed per trip; expired ones are cleaned up
+	_, err = ShareLinkCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "trip_id", Value: 1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Itinerary days and stops are always read in order within a trip
+	_, err = TripDayCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "trip_id", Value: 1}, {Key: "position", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = StopCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "trip_id", Value: 1}, {Key: "day_id", Value: 1}, {Key: "position", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ExpenseCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "trip_id", Value: 1}, {Key: "date", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	// One rate per currency pair and day, so re-importing a file replaces its rates
+	_, err = ExchangeRateCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "base", Value: 1}, {Key: "currency", Value: 1}, {Key: "date", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ExchangeRateCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "currency", Value: 1}, {Key: "date", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The audit log is queried by actor, action and time range
+	_, err = AuditCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "actor_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
@@ -0,0 +1,79 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+	"trip-planner/db"
+	"trip-planner/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// defaultTripStatusInterval is how often trips are moved along by the clock
+const defaultTripStatusInterval = 5 * time.Minute
+
+// StartTripStatusJob starts moving planned trips to in-progress when they begin and to completed
+// when they end, every TRIP_STATUS_INTERVAL (default 5m, 0 disables it). The updates are
+// conditional, so running it on several instances at once is harmless. Call after db.InitDB.
+func StartTripStatusJob() error {
+	interval := defaultTripStatusInterval
+	if value := os.Getenv("TRIP_STATUS_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return errors.New("invalid TRIP_STATUS_INTERVAL")
+		}
+		interval = parsed
+	}
+	if interval == 0 {
+		return nil
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			started, completed, err := AdvanceTripStatuses(ctx, time.Now())
+			cancel()
+			if err != nil {
+				log.Printf("Failed to advance trip statuses: %v", err)
+			} else if started > 0 || completed > 0 {
+				log.Printf("Trip statuses advanced: %d started, %d completed", started, completed)
+			}
+			<-ticker.C
+		}
+	}()
+	return nil
+}
+
+// AdvanceTripStatuses applies the clock-driven transitions as of now and reports how many trips
+// started and completed. A planned trip whose end has already passed goes straight to completed.
+func AdvanceTripStatuses(ctx context.Context, now time.Time) (started, completed int64, err error) {
+	result, err := db.TripCollection.UpdateMany(ctx,
+		bson.M{
+			"status":    models.TripStatusPlanned,
+			"starts_at": bson.M{"$lte": now},
+			"ends_at":   bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"status": models.TripStatusInProgress}},
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	started = result.ModifiedCount
+
+	result, err = db.TripCollection.UpdateMany(ctx,
+		bson.M{
+			"status":  bson.M{"$in": bson.A{models.TripStatusPlanned, models.TripStatusInProgress}},
+			"ends_at": bson.M{"$lte": now},
+		},
+		bson.M{"$set": bson.M{"status": models.TripStatusCompleted}},
+	)
+	if err != nil {
+		return started, 0, err
+	}
+	return started, result.ModifiedCount, nil
+}
@@ -0,0 +1,85 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Record is the failure history stored for a key
+type Record struct {
+	Failures    int       `bson:"failures"`
+	LastFailure time.Time `bson:"last_failure"`
+	LockedUntil time.Time `bson:"locked_until"`
+}
+
+// Store persists failure records. Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the record for key, or a zero Record if there is none
+	Get(ctx context.Context, key string) (Record, error)
+	// AddFailure counts a failure at now and returns the updated record. The count
+	// starts over when the previous failure is older than window.
+	AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (Record, error)
+	// Lock marks key as locked until the given time
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Reset forgets everything about key
+	Reset(ctx context.Context, key string) error
+}
+
+// Limiter locks a key out with exponential backoff once it exceeds its free attempts
+type Limiter struct {
+	Store        Store
+	Prefix       string        // namespaces keys so several limiters can share a store
+	FreeAttempts int           // failures allowed before the first lockout
+	BaseDelay    time.Duration // first lockout, doubled on every further failure
+	MaxDelay     time.Duration // lockouts never exceed this
+	Window       time.Duration // failures older than this are forgotten
+}
+
+// Check returns how long key must still wait, or zero if it may try now
+func (l *Limiter) Check(ctx context.Context, key string) (time.Duration, error) {
+	record, err := l.Store.Get(ctx, l.Prefix+key)
+	if err != nil {
+		return 0, err
+	}
+	return remaining(record.LockedUntil), nil
+}
+
+// Fail records a failed attempt and returns the lockout it triggered, if any
+func (l *Limiter) Fail(ctx context.Context, key string) (time.Duration, error) {
+	now := time.Now()
+	record, err := l.Store.AddFailure(ctx, l.Prefix+key, now, l.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	excess := record.Failures - l.FreeAttempts
+	if excess <= 0 {
+		return 0, nil
+	}
+
+	delay := l.MaxDelay
+	if excess <= 32 {
+		backoff := time.Duration(float64(l.BaseDelay) * math.Pow(2, float64(excess-1)))
+		if backoff > 0 && backoff < l.MaxDelay {
+			delay = backoff
+		}
+	}
+
+	if err := l.Store.Lock(ctx, l.Prefix+key, now.Add(delay)); err != nil {
+		return 0, err
+	}
+	return delay, nil
+}
+
+// Succeed clears the failure history for key
+func (l *Limiter) Succeed(ctx context.Context, key string) error {
+	return l.Store.Reset(ctx, l.Prefix+key)
+}
+
+func remaining(until time.Time) time.Duration {
+	if wait := time.Until(until); wait > 0 {
+		return wait
+	}
+	return 0
+}
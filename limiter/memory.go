@@ -0,0 +1,96 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps records in process memory. It only protects a single instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]*memoryRecord
+}
+
+type memoryRecord struct {
+	Record
+	expiresAt time.Time
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]*memoryRecord{}}
+}
+
+// Get returns the record for key
+func (s *MemoryStore) Get(ctx context.Context, key string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.records[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return Record{}, nil
+	}
+	return entry.Record, nil
+}
+
+// AddFailure counts a failure, starting over when the previous one is older than window
+func (s *MemoryStore) AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purge(now)
+
+	entry, ok := s.records[key]
+	if !ok {
+		entry = &memoryRecord{}
+		s.records[key] = entry
+	}
+	if now.Sub(entry.LastFailure) > window {
+		entry.Failures = 0
+	}
+	entry.Failures++
+	entry.LastFailure = now
+	entry.expiresAt = latest(now.Add(window), entry.LockedUntil)
+	return entry.Record, nil
+}
+
+// Lock marks key as locked until the given time
+func (s *MemoryStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.records[key]
+	if !ok {
+		entry = &memoryRecord{}
+		s.records[key] = entry
+	}
+	entry.LockedUntil = until
+	entry.expiresAt = latest(entry.expiresAt, until)
+	return nil
+}
+
+// Reset forgets key
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// purge drops expired records so the map doesn't grow without bound. Callers hold the lock.
+func (s *MemoryStore) purge(now time.Time) {
+	for key, entry := range s.records {
+		if now.After(entry.expiresAt) {
+			delete(s.records, key)
+		}
+	}
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,76 @@
+package limiter
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore keeps records in a MongoDB collection so every instance shares them.
+// The collection should have a TTL index on expires_at.
+type MongoStore struct {
+	Collection *mongo.Collection
+}
+
+// NewMongoStore returns a store backed by the collection
+func NewMongoStore(collection *mongo.Collection) *MongoStore {
+	return &MongoStore{Collection: collection}
+}
+
+// Get returns the record for key
+func (s *MongoStore) Get(ctx context.Context, key string) (Record, error) {
+	var record Record
+	err := s.Collection.FindOne(ctx, bson.M{"_id": key}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return Record{}, nil
+	}
+	return record, err
+}
+
+// AddFailure counts a failure atomically, starting over when the previous one is older than window
+func (s *MongoStore) AddFailure(ctx context.Context, key string, now time.Time, window time.Duration) (Record, error) {
+	stale := bson.M{"$or": bson.A{
+		bson.M{"$eq": bson.A{bson.M{"$type": "$last_failure"}, "missing"}},
+		bson.M{"$lt": bson.A{"$last_failure", now.Add(-window)}},
+	}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"failures":     bson.M{"$cond": bson.A{stale, 1, bson.M{"$add": bson.A{"$failures", 1}}}},
+			"last_failure": now,
+			"expires_at": bson.M{"$max": bson.A{
+				now.Add(window),
+				bson.M{"$ifNull": bson.A{"$locked_until", now}},
+			}},
+		}}},
+	}
+
+	var record Record
+	err := s.Collection.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&record)
+	return record, err
+}
+
+// Lock marks key as locked until the given time
+func (s *MongoStore) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := s.Collection.UpdateOne(ctx,
+		bson.M{"_id": key},
+		bson.A{bson.M{"$set": bson.M{
+			"locked_until": until,
+			"expires_at":   bson.M{"$max": bson.A{"$expires_at", until}},
+		}}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Reset forgets key
+func (s *MongoStore) Reset(ctx context.Context, key string) error {
+	_, err := s.Collection.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
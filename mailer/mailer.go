@@ -0,0 +1,139 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages to users
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Default is the mailer used by the application, set by Init
+var Default Mailer = NewWriterMailer(os.Stdout)
+
+// Init picks the mailer from the environment.
+//
+//	MAILER            smtp, file or stdout (default)
+//	MAIL_FROM         sender address for smtp (default no-reply@trip-planner.local)
+//	SMTP_HOST         SMTP server host
+//	SMTP_PORT         SMTP server port (default 25)
+//	SMTP_USERNAME     optional, enables PLAIN auth together with SMTP_PASSWORD
+//	SMTP_PASSWORD
+//	MAIL_OUTPUT_FILE  file messages are appended to when MAILER=file
+func Init() error {
+	switch strings.ToLower(os.Getenv("MAILER")) {
+	case "", "stdout":
+		Default = NewWriterMailer(os.Stdout)
+	case "file":
+		path := os.Getenv("MAIL_OUTPUT_FILE")
+		if path == "" {
+			return fmt.Errorf("MAIL_OUTPUT_FILE is required when MAILER=file")
+		}
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("opening mail output file: %w", err)
+		}
+		Default = NewWriterMailer(file)
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return fmt.Errorf("SMTP_HOST is required when MAILER=smtp")
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "25"
+		}
+		from := os.Getenv("MAIL_FROM")
+		if from == "" {
+			from = "no-reply@trip-planner.local"
+		}
+		Default = &SMTPMailer{
+			Addr:     net.JoinHostPort(host, port),
+			Host:     host,
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	default:
+		return fmt.Errorf("unsupported MAILER %q", os.Getenv("MAILER"))
+	}
+	return nil
+}
+
+// SMTPMailer sends messages through an SMTP server
+type SMTPMailer struct {
+	Addr     string
+	Host     string
+	From     string
+	Username string
+	Password string
+}
+
+// Send delivers the message. Authentication is only attempted when a username is configured,
+// so an unauthenticated local SMTP sink works out of the box.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, formatMessage(m.From, msg))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WriterMailer writes messages to an io.Writer instead of sending them, for local development
+type WriterMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterMailer returns a mailer that writes every message to w
+func NewWriterMailer(w io.Writer) *WriterMailer {
+	return &WriterMailer{w: w}
+}
+
+// Send writes the message followed by a separator line
+func (m *WriterMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.w, "%s\n----\n", formatMessage("trip-planner", msg))
+	return err
+}
+
+// formatMessage renders the message in RFC 5322 form
+func formatMessage(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -1,15 +1,58 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"trip-planner/controllers"
 	"trip-planner/db"
+	"trip-planner/jobs"
+	"trip-planner/mailer"
+	"trip-planner/oidc"
 	"trip-planner/routes"
+	"trip-planner/utils"
+
+	"github.com/joho/godotenv"
 )
 
 func main() {
+	// Load .env if present, real environment variables take precedence
+	if err := godotenv.Load(); err != nil {
+		log.Printf("No .env file loaded: %v", err)
+	}
+
+	// Load JWT signing and verification keys
+	err := utils.LoadJWTConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Pick the mailer used for verification and reset emails
+	err = mailer.Init()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Discover the OpenID Connect provider, if one is configured
+	err = oidc.Init(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Initialize DB
-	err := db.InitDB()
+	err = db.InitDB()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Count login failures in memory or in MongoDB
+	err = controllers.InitLoginLimiter()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Move trips through their lifecycle as their dates come and go
+	err = jobs.StartTripStatusJob()
 	if err != nil {
 		log.Fatal(err)
 	}
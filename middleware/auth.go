@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"trip-planner/models"
+	"trip-planner/policy"
+	"trip-planner/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Principal is the authenticated caller attached to the request context
+type Principal struct {
+	UserID    primitive.ObjectID
+	Role      string
+	SessionID string
+	Claims    *utils.Claims // nil when authenticated with an API key
+
+	// Set for API key callers only; interactive logins are not limited by scope
+	APIKeyID primitive.ObjectID
+	Scopes   []policy.Scope
+}
+
+// HasScope reports whether the caller may act within scope
+func (p *Principal) HasScope(scope policy.Scope) bool {
+	if p.APIKeyID.IsZero() {
+		return true
+	}
+	for _, granted := range p.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey struct{}
+
+var principalKey = contextKey{}
+
+// Authenticate validates the bearer token once and stores the caller in the request context.
+// Requests without a valid token are rejected with Unauthorized.
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := principalFromRequest(r)
+		if err != nil {
+			Unauthorized(w)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// AuthenticateWithAPIKeys works like Authenticate but also accepts "Authorization: ApiKey <key>".
+// Routes using it must also check scopes with RequireScope.
+func AuthenticateWithAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "ApiKey ") {
+			Authenticate(next).ServeHTTP(w, r)
+			return
+		}
+
+		apiKey, user, err := utils.AuthenticateAPIKey(r.Context(), strings.TrimPrefix(header, "ApiKey "))
+		if err != nil {
+			Unauthorized(w)
+			return
+		}
+
+		scopes := make([]policy.Scope, len(apiKey.Scopes))
+		for i, scope := range apiKey.Scopes {
+			scopes[i] = policy.Scope(scope)
+		}
+		// Keys act as a plain user even for admins, so a leaked script key can't moderate
+		principal := &Principal{
+			UserID:   user.ID,
+			Role:     models.RoleUser,
+			APIKeyID: apiKey.ID,
+			Scopes:   scopes,
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// AuthenticateOptional lets requests without credentials through anonymously, so handlers see a
+// nil principal. Requests that do send credentials are authenticated like AuthenticateWithAPIKeys
+// and rejected if they're invalid. Routes using it must check scopes with OptionalScope.
+func AuthenticateOptional(next http.Handler) http.Handler {
+	withCredentials := AuthenticateWithAPIKeys(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		withCredentials.ServeHTTP(w, r)
+	})
+}
+
+// OptionalScope is RequireScope for routes that also serve anonymous callers
+func OptionalScope(scope policy.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := CurrentPrincipal(r)
+			if principal != nil && !principal.HasScope(scope) {
+				http.Error(w, "API key lacks scope "+string(scope), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScope rejects API key callers whose key wasn't granted scope
+func RequireScope(scope policy.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := CurrentPrincipal(r)
+			if principal == nil {
+				Unauthorized(w)
+				return
+			}
+			if !principal.HasScope(scope) {
+				http.Error(w, "API key lacks scope "+string(scope), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePermission rejects callers whose role lacks the permission. It must run after Authenticate.
+func RequirePermission(permission policy.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := CurrentPrincipal(r)
+			if principal == nil {
+				Unauthorized(w)
+				return
+			}
+			if !policy.Can(principal.Role, permission) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// principalFromRequest validates the Authorization header and builds the caller
+func principalFromRequest(r *http.Request) (*Principal, error) {
+	claims, err := utils.ValidateJWT(r.Header.Get("Authorization"))
+	if err != nil {
+		return nil, err
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	role := claims.Role
+	if role == "" {
+		role = models.RoleUser
+	}
+
+	return &Principal{UserID: userID, Role: role, SessionID: claims.SessionID, Claims: claims}, nil
+}
+
+// WithPrincipal returns a copy of ctx carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// CurrentPrincipal returns the authenticated caller, or nil on public routes
+func CurrentPrincipal(r *http.Request) *Principal {
+	principal, _ := r.Context().Value(principalKey).(*Principal)
+	return principal
+}
+
+// Unauthorized writes the uniform 401 response used across the API
+func Unauthorized(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", `Bearer realm="trip-planner"`)
+	w.Header().Add("WWW-Authenticate", `ApiKey realm="trip-planner"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
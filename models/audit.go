@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Outcomes recorded on audit entries
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEntry records who did what to which resource. Entries are only ever inserted.
+type AuditEntry struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ActorID    primitive.ObjectID `bson:"actor_id" json:"actor_id"`                         // nil for anonymous actions such as a failed login
+	Identifier string             `bson:"identifier,omitempty" json:"identifier,omitempty"` // login name used, when there is no actor yet
+	Action     string             `bson:"action" json:"action"`
+	TargetType string             `bson:"target_type" json:"target_type"`
+	TargetID   primitive.ObjectID `bson:"target_id" json:"target_id"`
+	IP         string             `bson:"ip,omitempty" json:"ip,omitempty"`
+	Outcome    string             `bson:"outcome" json:"outcome"`
+	Details    string             `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
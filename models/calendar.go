@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TripConflict warns that one of a trip's members is already on another trip at the same time.
+// The other trip is only named when the caller can see it; otherwise the warning carries just
+// the member and when the trips overlap.
+type TripConflict struct {
+	UserID       primitive.ObjectID  `json:"user_id"`           // the member who is double-booked
+	TripID       *primitive.ObjectID `json:"trip_id,omitempty"` // the other trip
+	TripName     string              `json:"trip_name,omitempty"`
+	StartsAt     *time.Time          `json:"starts_at,omitempty"`
+	EndsAt       *time.Time          `json:"ends_at,omitempty"`
+	OverlapStart time.Time           `json:"overlap_start"`
+	OverlapEnd   time.Time           `json:"overlap_end"`
+}
+
+// TripOverlap is a pair of the caller's trips that overlap in time
+type TripOverlap struct {
+	First        TripSummary `json:"first"`
+	Second       TripSummary `json:"second"`
+	OverlapStart time.Time   `json:"overlap_start"`
+	OverlapEnd   time.Time   `json:"overlap_end"`
+}
+
+// TripSummary identifies a trip and when it happens
+type TripSummary struct {
+	ID        primitive.ObjectID `json:"id"`
+	Name      string             `json:"name"`
+	StartDate string             `json:"start_date"`
+	EndDate   string             `json:"end_date"`
+	TimeZone  string             `json:"time_zone"`
+	StartsAt  time.Time          `json:"starts_at"`
+	EndsAt    time.Time          `json:"ends_at"`
+}
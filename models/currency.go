@@ -0,0 +1,102 @@
+package models
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// currencyMinorUnits lists the ISO 4217 currencies in use and how many decimal places each
+// is counted in. Precious metals, testing codes and other codes without minor units are left out.
+var currencyMinorUnits = map[string]int{
+	"AED": 2, "AFN": 2, "ALL": 2, "AMD": 2, "ANG": 2, "AOA": 2, "ARS": 2, "AUD": 2, "AWG": 2, "AZN": 2,
+	"BAM": 2, "BBD": 2, "BDT": 2, "BGN": 2, "BHD": 3, "BIF": 0, "BMD": 2, "BND": 2, "BOB": 2, "BOV": 2,
+	"BRL": 2, "BSD": 2, "BTN": 2, "BWP": 2, "BYN": 2, "BZD": 2,
+	"CAD": 2, "CDF": 2, "CHE": 2, "CHF": 2, "CHW": 2, "CLF": 4, "CLP": 0, "CNY": 2, "COP": 2, "COU": 2,
+	"CRC": 2, "CUP": 2, "CVE": 2, "CZK": 2,
+	"DJF": 0, "DKK": 2, "DOP": 2, "DZD": 2,
+	"EGP": 2, "ERN": 2, "ETB": 2, "EUR": 2,
+	"FJD": 2, "FKP": 2,
+	"GBP": 2, "GEL": 2, "GHS": 2, "GIP": 2, "GMD": 2, "GNF": 0, "GTQ": 2, "GYD": 2,
+	"HKD": 2, "HNL": 2, "HTG": 2, "HUF": 2,
+	"IDR": 2, "ILS": 2, "INR": 2, "IQD": 3, "IRR": 2, "ISK": 0,
+	"JMD": 2, "JOD": 3, "JPY": 0,
+	"KES": 2, "KGS": 2, "KHR": 2, "KMF": 0, "KPW": 2, "KRW": 0, "KWD": 3, "KYD": 2, "KZT": 2,
+	"LAK": 2, "LBP": 2, "LKR": 2, "LRD": 2, "LSL": 2, "LYD": 3,
+	"MAD": 2, "MDL": 2, "MGA": 2, "MKD": 2, "MMK": 2, "MNT": 2, "MOP": 2, "MRU": 2, "MUR": 2, "MVR": 2,
+	"MWK": 2, "MXN": 2, "MXV": 2, "MYR": 2, "MZN": 2,
+	"NAD": 2, "NGN": 2, "NIO": 2, "NOK": 2, "NPR": 2, "NZD": 2,
+	"OMR": 3,
+	"PAB": 2, "PEN": 2, "PGK": 2, "PHP": 2, "PKR": 2, "PLN": 2, "PYG": 0,
+	"QAR": 2,
+	"RON": 2, "RSD": 2, "RUB": 2, "RWF": 0,
+	"SAR": 2, "SBD": 2, "SCR": 2, "SDG": 2, "SEK": 2, "SGD": 2, "SHP": 2, "SLE": 2, "SOS": 2, "SRD": 2,
+	"SSP": 2, "STN": 2, "SVC": 2, "SYP": 2, "SZL": 2,
+	"THB": 2, "TJS": 2, "TMT": 2, "TND": 3, "TOP": 2, "TRY": 2, "TTD": 2, "TWD": 2, "TZS": 2,
+	"UAH": 2, "UGX": 0, "USD": 2, "USN": 2, "UYI": 0, "UYU": 2, "UYW": 4, "UZS": 2,
+	"VED": 2, "VES": 2, "VND": 0, "VUV": 0,
+	"WST": 2,
+	"XAF": 0, "XCD": 2, "XCG": 2, "XOF": 0, "XPF": 0,
+	"YER": 2,
+	"ZAR": 2, "ZMW": 2, "ZWG": 2,
+}
+
+// Currency describes an ISO 4217 currency
+type Currency struct {
+	Code       string `json:"code"`
+	MinorUnits int    `json:"minor_units"` // decimal places amounts are rounded to
+}
+
+// ExchangeRate says that one unit of Base buys Rate units of Currency, from Date until the
+// next rate for the same pair
+type ExchangeRate struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Base       string             `bson:"base" json:"base"`
+	Currency   string             `bson:"currency" json:"currency"`
+	Rate       float64            `bson:"rate" json:"rate"`
+	Date       string             `bson:"date" json:"date"` // YYYY-MM-DD the rate takes effect
+	ImportedBy primitive.ObjectID `bson:"imported_by" json:"imported_by"`
+	ImportedAt time.Time          `bson:"imported_at" json:"imported_at"`
+}
+
+// Currencies returns every supported currency ordered by code
+func Currencies() []Currency {
+	currencies := make([]Currency, 0, len(currencyMinorUnits))
+	for code, minor := range currencyMinorUnits {
+		currencies = append(currencies, Currency{Code: code, MinorUnits: minor})
+	}
+	sort.Slice(currencies, func(i, j int) bool { return currencies[i].Code < currencies[j].Code })
+	return currencies
+}
+
+// ValidCurrencyCode reports whether code is an upper case ISO 4217 currency code
+func ValidCurrencyCode(code string) bool {
+	_, ok := currencyMinorUnits[code]
+	return ok
+}
+
+// MinorUnits returns the number of decimal places the currency is counted in
+func MinorUnits(currency string) int {
+	if minor, ok := currencyMinorUnits[currency]; ok {
+		return minor
+	}
+	return 2
+}
+
+// ToMinor converts an amount to whole minor units of the currency, such as cents for USD or
+// yen for JPY, rounding halves away from zero
+func ToMinor(amount float64, currency string) int64 {
+	return int64(math.Round(amount * math.Pow10(MinorUnits(currency))))
+}
+
+// FromMinor converts whole minor units of the currency back to an amount
+func FromMinor(units int64, currency string) float64 {
+	return float64(units) / math.Pow10(MinorUnits(currency))
+}
+
+// RoundAmount rounds an amount to the decimal places of the currency
+func RoundAmount(amount float64, currency string) float64 {
+	return FromMinor(ToMinor(amount, currency), currency)
+}
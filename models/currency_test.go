@@ -0,0 +1,88 @@
+package models
+
+import "testing"
+
+func TestMinorUnits(t *testing.T) {
+	tests := []struct {
+		currency string
+		want     int
+	}{
+		{"USD", 2},
+		{"EUR", 2},
+		{"JPY", 0},
+		{"KRW", 0},
+		{"KWD", 3},
+		{"BHD", 3},
+		{"CLF", 4},
+		{"XXX", 2}, // unknown codes fall back to two places
+	}
+	for _, tt := range tests {
+		if got := MinorUnits(tt.currency); got != tt.want {
+			t.Errorf("MinorUnits(%s) = %d, want %d", tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestToMinor(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		want     int64
+	}{
+		{12.34, "USD", 1234},
+		{0.1 + 0.2, "USD", 30},
+		{19.999, "EUR", 2000},
+		{0.005, "EUR", 1},
+		{-0.005, "EUR", -1},
+		{1500, "JPY", 1500},
+		{1500.5, "JPY", 1501},
+		{1499.4, "JPY", 1499},
+		{1.2345, "KWD", 1235},
+		{1.2344, "KWD", 1234},
+		{0.001, "KWD", 1},
+		{-2.5, "JPY", -3},
+	}
+	for _, tt := range tests {
+		if got := ToMinor(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("ToMinor(%v, %s) = %d, want %d", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestFromMinor(t *testing.T) {
+	tests := []struct {
+		units    int64
+		currency string
+		want     float64
+	}{
+		{1234, "USD", 12.34},
+		{1500, "JPY", 1500},
+		{1235, "KWD", 1.235},
+	}
+	for _, tt := range tests {
+		if got := FromMinor(tt.units, tt.currency); got != tt.want {
+			t.Errorf("FromMinor(%d, %s) = %v, want %v", tt.units, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestRoundAmount(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		want     float64
+	}{
+		{10.005, "USD", 10.01},
+		{10.004, "USD", 10},
+		{99.5, "JPY", 100},
+		{99.49, "JPY", 99},
+		{3.14159, "KWD", 3.142},
+		{3.1414, "KWD", 3.141},
+		{1.23456, "CLF", 1.2346},
+	}
+	for _, tt := range tests {
+		if got := RoundAmount(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("RoundAmount(%v, %s) = %v, want %v", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
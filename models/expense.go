@@ -0,0 +1,113 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of trip expense, which budget targets are set against
+const (
+	ExpenseCategoryLodging    = "lodging"
+	ExpenseCategoryTransport  = "transport"
+	ExpenseCategoryFood       = "food"
+	ExpenseCategoryActivities = "activities"
+	ExpenseCategoryShopping   = "shopping"
+	ExpenseCategoryOther      = "other"
+)
+
+// Expense is money one member paid on behalf of the trip. It is split evenly between its
+// participants, who must be members of the trip when it is recorded.
+type Expense struct {
+	ID           primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	TripID       primitive.ObjectID   `bson:"trip_id" json:"trip_id"`
+	Description  string               `bson:"description" json:"description"`
+	Amount       float64              `bson:"amount" json:"amount"`
+	Currency     string               `bson:"currency" json:"currency"` // ISO 4217 code
+	Category     string               `bson:"category" json:"category"`
+	PaidBy       primitive.ObjectID   `bson:"paid_by" json:"paid_by"`
+	Participants []primitive.ObjectID `bson:"participants" json:"participants"`
+	Date         string               `bson:"date" json:"date"` // local date, YYYY-MM-DD
+	CreatedBy    primitive.ObjectID   `bson:"created_by" json:"created_by"`
+	CreatedAt    time.Time            `bson:"created_at" json:"created_at"`
+}
+
+// BudgetTarget is how much the trip means to spend on one category, in the trip's currency
+type BudgetTarget struct {
+	Category string  `bson:"category" json:"category"`
+	Amount   float64 `bson:"amount" json:"amount"`
+}
+
+// CategoryTotal is what was spent on one category in one currency, set against the budget
+// when it is in the trip's currency and there is a target for it
+type CategoryTotal struct {
+	Category  string   `json:"category"`
+	Currency  string   `json:"currency"`
+	Spent     float64  `json:"spent"`
+	Budget    *float64 `json:"budget,omitempty"`
+	Remaining *float64 `json:"remaining,omitempty"`
+}
+
+// BudgetReport compares a trip's spending with its budget. Expenses are converted to the trip's
+// currency at the rate in effect on their date; those with no known rate are totalled in
+// their own currency under Unconverted and not counted against the budget.
+type BudgetReport struct {
+	Currency    string          `json:"currency,omitempty"`
+	Targets     []BudgetTarget  `json:"targets"`
+	Categories  []CategoryTotal `json:"categories"`
+	Unconverted []CategoryTotal `json:"unconverted,omitempty"`
+	TotalBudget float64         `json:"total_budget"`
+	TotalSpent  float64         `json:"total_spent"` // in the trip's currency
+}
+
+// MemberBalance is what one member paid and owes in one currency. A positive Net means the
+// others owe them money. Expenses are settled in the trip's currency when they can be converted.
+type MemberBalance struct {
+	UserID   primitive.ObjectID `json:"user_id"`
+	Currency string             `json:"currency"`
+	Paid     float64            `json:"paid"`
+	Owed     float64            `json:"owed"`
+	Net      float64            `json:"net"`
+}
+
+// Transfer is a payment that settles part of the debts between two members
+type Transfer struct {
+	From     primitive.ObjectID `json:"from"`
+	To       primitive.ObjectID `json:"to"`
+	Amount   float64            `json:"amount"`
+	Currency string             `json:"currency"`
+}
+
+// Settlement is the outcome of a settle-up: everyone's balance and the payments that even them out
+type Settlement struct {
+	Balances  []MemberBalance `json:"balances"`
+	Transfers []Transfer      `json:"transfers"`
+}
+
+// ValidExpenseCategory reports whether category is a known kind of expense
+func ValidExpenseCategory(category string) bool {
+	switch category {
+	case ExpenseCategoryLodging, ExpenseCategoryTransport, ExpenseCategoryFood,
+		ExpenseCategoryActivities, ExpenseCategoryShopping, ExpenseCategoryOther:
+		return true
+	}
+	return false
+}
+
+// Shares splits the expense evenly between its participants, in minor units of its currency
+// and in participant order. Units that don't divide evenly go one each to the first participants.
+func (e *Expense) Shares() []int64 {
+	if len(e.Participants) == 0 {
+		return nil
+	}
+	total := ToMinor(e.Amount, e.Currency)
+	count := int64(len(e.Participants))
+	shares := make([]int64, count)
+	for i := range shares {
+		shares[i] = total / count
+		if int64(i) < total%count {
+			shares[i]++
+		}
+	}
+	return shares
+}
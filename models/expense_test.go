@@ -0,0 +1,34 @@
+package models
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestExpenseShares(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		people   int
+		want     []int64
+	}{
+		{10, "USD", 3, []int64{334, 333, 333}},
+		{1000, "JPY", 3, []int64{334, 333, 333}},
+		{1, "KWD", 3, []int64{334, 333, 333}},
+		{0.02, "EUR", 3, []int64{1, 1, 0}},
+	}
+	for _, tt := range tests {
+		expense := Expense{Amount: tt.amount, Currency: tt.currency, Participants: make([]primitive.ObjectID, tt.people)}
+		got := expense.Shares()
+		if len(got) != len(tt.want) {
+			t.Fatalf("Shares(%v %s) = %v, want %v", tt.amount, tt.currency, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Shares(%v %s) = %v, want %v", tt.amount, tt.currency, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TripInvite lets registered users join a trip with a fixed role by following a link.
+// Only a hash of the signed invite token is stored.
+type TripInvite struct {
+	ID         primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	TripID     primitive.ObjectID   `bson:"trip_id" json:"trip_id"`
+	CreatedBy  primitive.ObjectID   `bson:"created_by" json:"created_by"`
+	Role       string               `bson:"role" json:"role"`
+	TokenHash  string               `bson:"token_hash" json:"-"`
+	MaxUses    int                  `bson:"max_uses" json:"max_uses"` // 0 means unlimited
+	Uses       int                  `bson:"uses" json:"uses"`
+	AcceptedBy []primitive.ObjectID `bson:"accepted_by" json:"accepted_by"`
+	CreatedAt  time.Time            `bson:"created_at" json:"created_at"`
+	ExpiresAt  time.Time            `bson:"expires_at" json:"expires_at"`
+	RevokedAt  *time.Time           `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
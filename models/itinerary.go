@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of itinerary stop
+const (
+	StopTypeAttraction = "attraction"
+	StopTypeActivity   = "activity"
+	StopTypeFood       = "food"
+	StopTypeLodging    = "lodging"
+	StopTypeTransport  = "transport"
+	StopTypeOther      = "other"
+)
+
+// TripDay is one day of a trip's itinerary. Days are ordered by Position.
+type TripDay struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TripID    primitive.ObjectID `bson:"trip_id" json:"trip_id"`
+	Position  int                `bson:"position" json:"position"`
+	Title     string             `bson:"title" json:"title"`
+	Notes     string             `bson:"notes,omitempty" json:"notes,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	Stops     []Stop             `bson:"-" json:"stops,omitempty"` // filled in when the itinerary is read
+}
+
+// Stop is a place or event on a trip. Stops without a day are not scheduled yet.
+// Within a day (or among the unscheduled stops) they are ordered by Position.
+type Stop struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TripID    primitive.ObjectID `bson:"trip_id" json:"trip_id"`
+	DayID     primitive.ObjectID `bson:"day_id" json:"day_id"` // nil when unscheduled
+	Position  int                `bson:"position" json:"position"`
+	Name      string             `bson:"name" json:"name"`
+	Type      string             `bson:"type" json:"type"`
+	Location  string             `bson:"location,omitempty" json:"location,omitempty"`
+	Latitude  *float64           `bson:"latitude,omitempty" json:"latitude,omitempty"`
+	Longitude *float64           `bson:"longitude,omitempty" json:"longitude,omitempty"`
+	StartTime string             `bson:"start_time,omitempty" json:"start_time,omitempty"` // local "HH:MM"
+	EndTime   string             `bson:"end_time,omitempty" json:"end_time,omitempty"`     // local "HH:MM"
+	Notes     string             `bson:"notes,omitempty" json:"notes,omitempty"`
+	Cost      float64            `bson:"cost,omitempty" json:"cost,omitempty"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// ValidStopType reports whether stopType is a known kind of stop
+func ValidStopType(stopType string) bool {
+	switch stopType {
+	case StopTypeAttraction, StopTypeActivity, StopTypeFood, StopTypeLodging, StopTypeTransport, StopTypeOther:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OIDCState remembers an authorization request between the redirect to the identity
+// provider and its callback. It is looked up by the hash of the state parameter.
+type OIDCState struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	StateHash    string             `bson:"state_hash"`
+	Nonce        string             `bson:"nonce"`
+	CodeVerifier string             `bson:"code_verifier"`
+	CreatedAt    time.Time          `bson:"created_at"`
+	ExpiresAt    time.Time          `bson:"expires_at"`
+}
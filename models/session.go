@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is one login on one device. Its ID is the refresh token family, which access
+// tokens carry as their sid claim.
+type Session struct {
+	ID           string             `bson:"_id" json:"id"`
+	UserID       primitive.ObjectID `bson:"user_id" json:"user_id"`
+	UserAgent    string             `bson:"user_agent" json:"user_agent"`
+	IP           string             `bson:"ip" json:"ip"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	LastSeenAt   time.Time          `bson:"last_seen_at" json:"last_seen_at"`
+	ExpiresAt    time.Time          `bson:"expires_at" json:"expires_at"`
+	TerminatedAt *time.Time         `bson:"terminated_at,omitempty" json:"terminated_at,omitempty"`
+	Current      bool               `bson:"-" json:"current"` // set when listing for the caller's own session
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ShareLink gives anyone holding its URL read-only access to a trip and its comments, without
+// an account. Only a hash of the signed share token and of the optional password is stored.
+type ShareLink struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TripID       primitive.ObjectID `bson:"trip_id" json:"trip_id"`
+	CreatedBy    primitive.ObjectID `bson:"created_by" json:"created_by"`
+	TokenHash    string             `bson:"token_hash" json:"-"`
+	PasswordHash string             `bson:"password_hash,omitempty" json:"-"`
+	HasPassword  bool               `bson:"-" json:"has_password"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt    *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // nil for links that don't expire
+	LastUsedAt   *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	RevokedAt    *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
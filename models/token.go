@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken is a single-use refresh token. Rotating a token creates a new
+// row in the same family, so reuse of an old token can revoke the whole chain.
+type RefreshToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Family    string             `bson:"family" json:"family"`
+	TokenHash string             `bson:"token_hash" json:"-"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time         `bson:"used_at,omitempty" json:"used_at,omitempty"`
+	Revoked   bool               `bson:"revoked" json:"revoked"`
+}
+
+// RevokedToken marks an access token (by jti) or a whole token family as revoked
+// until ExpiresAt, after which every token it covers has expired anyway.
+type RevokedToken struct {
+	ID        string             `bson:"_id" json:"id"` // "jti:<id>" or "family:<id>"
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	Reason    string             `bson:"reason" json:"reason"`
+	ExpiresAt time.Time          `bson:"expires_at" json:"expires_at"`
+}
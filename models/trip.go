@@ -1,6 +1,47 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Roles a member can hold on a trip, from most to least privileged
+const (
+	TripRoleOwner  = "owner"
+	TripRoleEditor = "editor"
+	TripRoleViewer = "viewer"
+)
+
+// Who can see a trip besides its members. Trips saved before visibility existed have none and are private.
+const (
+	TripVisibilityPrivate  = "private"  // members only
+	TripVisibilityUnlisted = "unlisted" // anyone with the ID, but not listed
+	TripVisibilityPublic   = "public"   // listed in the explore feed
+)
+
+// Lifecycle states of a trip. Trips saved before statuses existed have none and count as drafts.
+const (
+	TripStatusDraft      = "draft"
+	TripStatusPlanned    = "planned"
+	TripStatusInProgress = "in-progress"
+	TripStatusCompleted  = "completed"
+	TripStatusCancelled  = "cancelled"
+)
+
+// TripDateLayout is the format of a trip's start and end dates
+const TripDateLayout = "2006-01-02"
+
+// tripTransitions lists the statuses a trip may be moved to by hand from each status. The
+// clock-driven moves into in-progress and completed are made by the status job.
+var tripTransitions = map[string][]string{
+	TripStatusDraft:      {TripStatusPlanned, TripStatusCancelled},
+	TripStatusPlanned:    {TripStatusDraft, TripStatusInProgress, TripStatusCancelled},
+	TripStatusInProgress: {TripStatusCompleted, TripStatusCancelled},
+	TripStatusCompleted:  {},
+	TripStatusCancelled:  {TripStatusDraft},
+}
 
 // Trip represents a trip entry
 type Trip struct {
@@ -9,6 +50,135 @@ type Trip struct {
 	Category    string             `json:"category" bson:"category"`
 	Region      string             `json:"region" bson:"region"`
 	Description string             `json:"description" bson:"description"`
-	Attractions string             `json:"attractions" bson:"attractions"`
-	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Attractions string             `json:"attractions" bson:"attractions,omitempty"`   // Deprecated: free text from before itineraries, see Stop
+	UserID      primitive.ObjectID `json:"user_id" bson:"user_id"`                     // The creator, always an owner
+	Members     []TripMember       `json:"members,omitempty" bson:"members,omitempty"` // Collaborators other than the creator
+	Visibility  string             `json:"visibility,omitempty" bson:"visibility,omitempty"`
+	Status      string             `json:"status,omitempty" bson:"status,omitempty"`
+	StartDate   string             `json:"start_date,omitempty" bson:"start_date,omitempty"` // local date, YYYY-MM-DD
+	EndDate     string             `json:"end_date,omitempty" bson:"end_date,omitempty"`     // local date, inclusive
+	TimeZone    string             `json:"time_zone,omitempty" bson:"time_zone,omitempty"`   // IANA name the dates are in
+	StartsAt    *time.Time         `json:"starts_at,omitempty" bson:"starts_at,omitempty"`   // start of StartDate, derived
+	EndsAt      *time.Time         `json:"ends_at,omitempty" bson:"ends_at,omitempty"`       // end of EndDate, derived
+	Currency    string             `json:"currency,omitempty" bson:"currency,omitempty"`     // base currency of the budget and expense totals
+	Budget      []BudgetTarget     `json:"budget,omitempty" bson:"budget,omitempty"`         // spending targets per expense category
+	Conflicts   []TripConflict     `json:"conflicts,omitempty" bson:"-"`                     // warnings returned after a create or update
+}
+
+// TripMember gives another user access to a trip
+type TripMember struct {
+	UserID  primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Role    string             `json:"role" bson:"role"`
+	AddedAt time.Time          `json:"added_at" bson:"added_at"`
+}
+
+// ValidTripRole reports whether role is one a trip member can hold
+func ValidTripRole(role string) bool {
+	switch role {
+	case TripRoleOwner, TripRoleEditor, TripRoleViewer:
+		return true
+	}
+	return false
+}
+
+// ValidTripVisibility reports whether visibility is one a trip can have
+func ValidTripVisibility(visibility string) bool {
+	switch visibility {
+	case TripVisibilityPrivate, TripVisibilityUnlisted, TripVisibilityPublic:
+		return true
+	}
+	return false
+}
+
+// OpenToNonMembers reports whether people outside the trip may read it
+func (t *Trip) OpenToNonMembers() bool {
+	return t.Visibility == TripVisibilityPublic || t.Visibility == TripVisibilityUnlisted
+}
+
+// ReadOnlyView returns the copy of the trip shown to people who aren't members, without the
+// member list or budget
+func (t *Trip) ReadOnlyView() Trip {
+	view := *t
+	view.Members = nil
+	view.Budget = nil
+	view.Conflicts = nil
+	return view
+}
+
+// Summary returns the identifying fields and dates of a dated trip
+func (t *Trip) Summary() TripSummary {
+	summary := TripSummary{ID: t.ID, Name: t.Name, StartDate: t.StartDate, EndDate: t.EndDate, TimeZone: t.TimeZone}
+	if t.StartsAt != nil && t.EndsAt != nil {
+		summary.StartsAt, summary.EndsAt = *t.StartsAt, *t.EndsAt
+	}
+	return summary
+}
+
+// ValidTripStatus reports whether status is a trip lifecycle state
+func ValidTripStatus(status string) bool {
+	_, ok := tripTransitions[status]
+	return ok
+}
+
+// CanTransitionTrip reports whether a trip may be moved by hand from one status to another.
+// A missing status counts as draft.
+func CanTransitionTrip(from, to string) bool {
+	if from == "" {
+		from = TripStatusDraft
+	}
+	for _, allowed := range tripTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveSchedule validates the trip's dates and time zone and derives StartsAt and EndsAt.
+// Dates are optional but come in pairs; the time zone defaults to UTC.
+func (t *Trip) ResolveSchedule() error {
+	t.StartsAt, t.EndsAt = nil, nil
+	if t.StartDate == "" && t.EndDate == "" {
+		return nil
+	}
+	if t.StartDate == "" || t.EndDate == "" {
+		return errors.New("start_date and end_date must be given together")
+	}
+	if t.TimeZone == "" {
+		t.TimeZone = "UTC"
+	}
+	location, err := time.LoadLocation(t.TimeZone)
+	if err != nil {
+		return errors.New("time_zone must be an IANA time zone name")
+	}
+	start, err := time.ParseInLocation(TripDateLayout, t.StartDate, location)
+	if err != nil {
+		return errors.New("start_date must be YYYY-MM-DD")
+	}
+	end, err := time.ParseInLocation(TripDateLayout, t.EndDate, location)
+	if err != nil {
+		return errors.New("end_date must be YYYY-MM-DD")
+	}
+	if end.Before(start) {
+		return errors.New("end_date can't be before start_date")
+	}
+
+	// The end date is inclusive, so the trip ends at the following local midnight
+	end = end.AddDate(0, 0, 1)
+	start, end = start.UTC(), end.UTC()
+	t.StartsAt, t.EndsAt = &start, &end
+	return nil
+}
+
+// MemberRole returns the role the user holds on the trip, or "" if they aren't a member
+func (t *Trip) MemberRole(userID primitive.ObjectID) string {
+	if t.UserID == userID {
+		return TripRoleOwner
+	}
+	for _, member := range t.Members {
+		if member.UserID == userID {
+			return member.Role
+		}
+	}
+	return ""
 }
@@ -4,10 +4,59 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Roles a user can hold, from least to most privileged
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Identity links a user to an account at an external OpenID Connect provider
+type Identity struct {
+	Issuer  string `bson:"issuer" json:"issuer"`
+	Subject string `bson:"subject" json:"subject"`
+}
+
 // User represents a user in the system
 type User struct {
 	ID       primitive.ObjectID `bson:"_id,omitempty" json:"user_id"` // MongoDB will automatically assign this
 	Email    string             `bson:"email" json:"email"`
 	Username string             `bson:"username" json:"username"`
-	Password string             `bson:"password" json:"password"`
+	Password string             `bson:"password" json:"-"` // bcrypt hash, never serialized
+	Role     string             `bson:"role,omitempty" json:"role"`
+	Disabled bool               `bson:"disabled" json:"disabled"`
+
+	// Service accounts belong to automation, they have no password and only use API keys
+	ServiceAccount bool `bson:"service_account,omitempty" json:"service_account,omitempty"`
+
+	EmailVerified bool `bson:"email_verified" json:"email_verified"`
+
+	// Two-factor authentication, secrets never leave the server
+	MFAEnabled        bool     `bson:"mfa_enabled" json:"mfa_enabled"`
+	MFASecret         string   `bson:"mfa_secret,omitempty" json:"-"`
+	MFAPendingSecret  string   `bson:"mfa_pending_secret,omitempty" json:"-"` // awaiting a confirmation code
+	MFALastStep       int64    `bson:"mfa_last_step,omitempty" json:"-"`      // last accepted TOTP time step, blocks replays
+	MFARecoveryHashes []string `bson:"mfa_recovery_hashes,omitempty" json:"-"`
+
+	// External identities the user can sign in with instead of a password
+	Identities []Identity `bson:"identities,omitempty" json:"identities,omitempty"`
+
+	// Profile fields, editable through /me
+	DisplayName string `bson:"display_name,omitempty" json:"display_name,omitempty"`
+	AvatarURL   string `bson:"avatar_url,omitempty" json:"avatar_url,omitempty"`
+	HomeRegion  string `bson:"home_region,omitempty" json:"home_region,omitempty"`
+	Bio         string `bson:"bio,omitempty" json:"bio,omitempty"`
+}
+
+// EffectiveRole returns the user's role, treating rows created before roles existed as plain users
+func (u *User) EffectiveRole() string {
+	if u.Role == "" {
+		return RoleUser
+	}
+	return u.Role
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
 }
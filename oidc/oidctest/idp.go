@@ -0,0 +1,250 @@
+// Package oidctest runs an in-process OpenID Connect identity provider for tests. It serves
+// discovery, a JWKS and a token endpoint that enforces PKCE, and signs ID tokens with an RSA
+// key that can be rotated.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Identity is the user who signs in at the provider
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// IdP is a running mock identity provider
+type IdP struct {
+	Server   *httptest.Server
+	ClientID string
+
+	mu          sync.Mutex
+	key         *rsa.PrivateKey
+	kid         string
+	generation  int
+	grants      map[string]grant
+	jwksFetches int
+}
+
+// grant is an authorization code waiting to be redeemed
+type grant struct {
+	challenge   string
+	redirectURI string
+	nonce       string
+	identity    Identity
+}
+
+// New starts a provider that accepts the client. Close it when done.
+func New(clientID string) (*IdP, error) {
+	idp := &IdP{ClientID: clientID, grants: map[string]grant{}}
+	if err := idp.RotateKey(); err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+	return idp, nil
+}
+
+// Close shuts the provider down
+func (idp *IdP) Close() {
+	idp.Server.Close()
+}
+
+// Issuer is the provider's issuer URL, which discovery is read from
+func (idp *IdP) Issuer() string {
+	return idp.Server.URL
+}
+
+// RotateKey replaces the signing key with a new one under a new kid. The JWKS only publishes
+// the new key.
+func (idp *IdP) RotateKey() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.generation++
+	idp.key = key
+	idp.kid = fmt.Sprintf("key-%d", idp.generation)
+	return nil
+}
+
+// JWKSFetches counts the requests made to the JWKS endpoint
+func (idp *IdP) JWKSFetches() int {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	return idp.jwksFetches
+}
+
+// Authorize plays the user's visit to the authorization URL: it checks the request, signs the
+// identity in and returns the redirect back to the client carrying the code and state
+func (idp *IdP) Authorize(authURL string, identity Identity) (*url.URL, error) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		return nil, err
+	}
+	query := parsed.Query()
+	switch {
+	case query.Get("response_type") != "code":
+		return nil, errors.New("response_type must be code")
+	case query.Get("client_id") != idp.ClientID:
+		return nil, errors.New("unknown client_id")
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		return nil, errors.New("PKCE with S256 is required")
+	case query.Get("state") == "" || query.Get("nonce") == "":
+		return nil, errors.New("state and nonce are required")
+	}
+
+	code, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	idp.mu.Lock()
+	idp.grants[code] = grant{
+		challenge:   query.Get("code_challenge"),
+		redirectURI: query.Get("redirect_uri"),
+		nonce:       query.Get("nonce"),
+		identity:    identity,
+	}
+	idp.mu.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		return nil, err
+	}
+	back := redirect.Query()
+	back.Set("code", code)
+	back.Set("state", query.Get("state"))
+	redirect.RawQuery = back.Encode()
+	return redirect, nil
+}
+
+// SignIDToken signs an ID token for the identity with the current key
+func (idp *IdP) SignIDToken(identity Identity, nonce string) (string, error) {
+	idp.mu.Lock()
+	key, kid := idp.key, idp.kid
+	idp.mu.Unlock()
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            idp.Issuer(),
+		"aud":            idp.ClientID,
+		"sub":            identity.Subject,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          identity.Email,
+		"email_verified": identity.EmailVerified,
+		"name":           identity.Name,
+	})
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+func (idp *IdP) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{
+		"issuer":                 idp.Issuer(),
+		"authorization_endpoint": idp.Issuer() + "/authorize",
+		"token_endpoint":         idp.Issuer() + "/token",
+		"jwks_uri":               idp.Issuer() + "/jwks",
+	})
+}
+
+func (idp *IdP) jwks(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	idp.jwksFetches++
+	public, kid := idp.key.PublicKey, idp.kid
+	idp.mu.Unlock()
+
+	writeJSON(w, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}},
+	})
+}
+
+// token redeems an authorization code once, checking the PKCE verifier against the challenge
+func (idp *IdP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	idp.mu.Lock()
+	saved, ok := idp.grants[code]
+	delete(idp.grants, code)
+	idp.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code":
+		tokenError(w, "unsupported_grant_type")
+		return
+	case !ok, r.PostForm.Get("client_id") != idp.ClientID, r.PostForm.Get("redirect_uri") != saved.redirectURI,
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != saved.challenge:
+		tokenError(w, "invalid_grant")
+		return
+	}
+
+	idToken, err := idp.SignIDToken(saved.identity, saved.nonce)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"access_token": "access-" + code,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
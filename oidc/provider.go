@@ -0,0 +1,365 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Provider is an OpenID Connect identity provider configured through discovery
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string
+
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time     // when the JWKS was last requested
+	refresh   chan struct{} // closed when the download in flight finishes, nil when there is none
+}
+
+// minKeyRefresh is the least time between JWKS downloads, so tokens with made-up kids can't
+// make every login wait on the identity provider
+const minKeyRefresh = time.Minute
+
+// IDClaims are the ID token claims the application relies on
+type IDClaims struct {
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	jwt.RegisteredClaims
+}
+
+// Default is the configured provider, nil when OIDC login is disabled
+var Default *Provider
+
+// Init configures OIDC login from the environment. It does nothing when OIDC_ISSUER is unset.
+//
+//	OIDC_ISSUER         issuer URL, discovery is read from <issuer>/.well-known/openid-configuration
+//	OIDC_CLIENT_ID      client registered with the provider
+//	OIDC_CLIENT_SECRET  optional, public clients rely on PKCE alone
+//	OIDC_REDIRECT_URL   callback URL registered with the provider
+//	OIDC_SCOPES         space separated, default "openid email profile"
+func Init(ctx context.Context) error {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+	clientID := os.Getenv("OIDC_CLIENT_ID")
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if clientID == "" || redirectURL == "" {
+		return errors.New("OIDC_CLIENT_ID and OIDC_REDIRECT_URL are required when OIDC_ISSUER is set")
+	}
+	scopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	provider, err := Discover(ctx, issuer, clientID, os.Getenv("OIDC_CLIENT_SECRET"), redirectURL, scopes)
+	if err != nil {
+		return err
+	}
+	Default = provider
+	return nil
+}
+
+// Discover reads the provider metadata and returns a ready provider
+func Discover(ctx context.Context, issuer, clientID, clientSecret, redirectURL string, scopes []string) (*Provider, error) {
+	p := &Provider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       scopes,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+
+	var metadata struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, fmt.Errorf("OIDC discovery: %w", err)
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("OIDC discovery: issuer mismatch %q", metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery: incomplete provider metadata")
+	}
+
+	p.AuthorizationEndpoint = metadata.AuthorizationEndpoint
+	p.TokenEndpoint = metadata.TokenEndpoint
+	p.JWKSURI = metadata.JWKSURI
+	return p, nil
+}
+
+// AuthCodeURL builds the authorization request URL using PKCE (S256)
+func (p *Provider) AuthCodeURL(state, nonce, codeVerifier string) string {
+	challenge := sha256.Sum256([]byte(codeVerifier))
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange redeems an authorization code and returns the verified ID token claims
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*IDClaims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %s", resp.Status)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// VerifyIDToken checks the signature against the provider's JWKS, then issuer, audience,
+// expiry and nonce
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*IDClaims, error) {
+	parser := jwt.Parser{ValidMethods: []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}}
+	token, err := parser.ParseWithClaims(raw, &IDClaims{}, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*IDClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid id_token")
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != p.Issuer {
+		return nil, errors.New("id_token issuer mismatch")
+	}
+	if !claims.VerifyAudience(p.ClientID, true) {
+		return nil, errors.New("id_token audience mismatch")
+	}
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("id_token has no expiry")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+	return claims, nil
+}
+
+// key returns the verification key for kid. An unknown kid refetches the JWKS so provider
+// key rotation is picked up, at most once every minKeyRefresh. The download happens outside
+// the lock; concurrent callers wait for it rather than starting their own.
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+	for {
+		p.mu.Lock()
+		if key, ok := p.lookup(kid); ok {
+			p.mu.Unlock()
+			return key, nil
+		}
+		if wait := p.refresh; wait != nil {
+			p.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if !p.fetchedAt.IsZero() && time.Since(p.fetchedAt) < minKeyRefresh {
+			p.mu.Unlock()
+			return nil, fmt.Errorf("no JWKS key for kid %q", kid)
+		}
+		done := make(chan struct{})
+		p.refresh = done
+		p.fetchedAt = time.Now()
+		p.mu.Unlock()
+
+		keys, err := p.fetchKeys(ctx)
+
+		p.mu.Lock()
+		if err == nil {
+			p.keys = keys
+		}
+		p.refresh = nil
+		close(done)
+		p.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// lookup finds a key by kid. A token without kid is accepted only when the set has a single key.
+func (p *Provider) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// fetchKeys downloads the JWKS and returns its signing keys by kid
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching JWKS: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// jwk is a JSON Web Key as published in a JWKS (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
@@ -0,0 +1,183 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"trip-planner/oidc/oidctest"
+)
+
+const testRedirectURL = "https://trips.example.com/auth/oidc/callback"
+
+func startIdP(t *testing.T) (*oidctest.IdP, *Provider) {
+	t.Helper()
+	idp, err := oidctest.New("trip-planner")
+	if err != nil {
+		t.Fatalf("starting IdP: %v", err)
+	}
+	t.Cleanup(idp.Close)
+
+	provider, err := Discover(context.Background(), idp.Issuer(), "trip-planner", "", testRedirectURL,
+		[]string{"openid", "email", "profile"})
+	if err != nil {
+		t.Fatalf("Discover: %v", err)
+	}
+	return idp, provider
+}
+
+var alice = oidctest.Identity{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}
+
+func TestDiscover(t *testing.T) {
+	idp, provider := startIdP(t)
+	if provider.TokenEndpoint != idp.Issuer()+"/token" || provider.JWKSURI != idp.Issuer()+"/jwks" {
+		t.Errorf("endpoints = %q, %q", provider.TokenEndpoint, provider.JWKSURI)
+	}
+	if _, err := Discover(context.Background(), idp.Issuer()+"/other", "trip-planner", "", testRedirectURL, nil); err == nil {
+		t.Error("Discover accepted metadata for a different issuer")
+	}
+}
+
+func TestAuthCodeURLUsesPKCE(t *testing.T) {
+	_, provider := startIdP(t)
+	authURL, err := url.Parse(provider.AuthCodeURL("state-1", "nonce-1", "verifier-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := authURL.Query()
+	challenge := sha256.Sum256([]byte("verifier-1"))
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             "trip-planner",
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email profile",
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge":        base64.RawURLEncoding.EncodeToString(challenge[:]),
+		"code_challenge_method": "S256",
+	}
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	if query.Has("code_verifier") {
+		t.Error("the verifier leaked into the authorization URL")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name      string
+		verifier  string // sent to the token endpoint
+		nonce     string // expected by the client
+		wantError string
+	}{
+		{name: "valid", verifier: "verifier-1", nonce: "nonce-1"},
+		{name: "wrong verifier", verifier: "verifier-2", nonce: "nonce-1", wantError: "token endpoint returned"},
+		{name: "nonce mismatch", verifier: "verifier-1", nonce: "nonce-2", wantError: "nonce mismatch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idp, provider := startIdP(t)
+			redirect, err := idp.Authorize(provider.AuthCodeURL("state-1", "nonce-1", "verifier-1"), alice)
+			if err != nil {
+				t.Fatalf("Authorize: %v", err)
+			}
+			if got := redirect.Query().Get("state"); got != "state-1" {
+				t.Fatalf("state = %q, want state-1", got)
+			}
+			if !strings.HasPrefix(redirect.String(), testRedirectURL+"?") {
+				t.Fatalf("redirected to %q", redirect)
+			}
+
+			claims, err := provider.Exchange(context.Background(), redirect.Query().Get("code"), tt.verifier, tt.nonce)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Fatalf("Exchange error = %v, want %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange: %v", err)
+			}
+			if claims.Subject != alice.Subject || claims.Email != alice.Email || !claims.EmailVerified {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestExchangeCodeIsSingleUse(t *testing.T) {
+	idp, provider := startIdP(t)
+	redirect, err := idp.Authorize(provider.AuthCodeURL("state-1", "nonce-1", "verifier-1"), alice)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	code := redirect.Query().Get("code")
+	if _, err := provider.Exchange(context.Background(), code, "verifier-1", "nonce-1"); err != nil {
+		t.Fatalf("first Exchange: %v", err)
+	}
+	if _, err := provider.Exchange(context.Background(), code, "verifier-1", "nonce-1"); err == nil {
+		t.Error("a redeemed code was accepted again")
+	}
+}
+
+func TestKeyRefreshIsRateLimited(t *testing.T) {
+	idp, provider := startIdP(t)
+	verify := func() error {
+		raw, err := idp.SignIDToken(alice, "nonce-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = provider.VerifyIDToken(context.Background(), raw, "nonce-1")
+		return err
+	}
+
+	// The first token downloads the JWKS once, however many verify it at the same time
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- verify()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("VerifyIDToken: %v", err)
+		}
+	}
+	if got := idp.JWKSFetches(); got != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", got)
+	}
+
+	// A rotated key right after a download is not fetched again
+	if err := idp.RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(); err == nil || !strings.Contains(err.Error(), "no JWKS key") {
+		t.Fatalf("VerifyIDToken with a new kid = %v, want an unknown kid error", err)
+	}
+	if got := idp.JWKSFetches(); got != 1 {
+		t.Fatalf("JWKS fetched %d times within the refresh interval, want 1", got)
+	}
+
+	// Once the interval has passed the rotated key is picked up
+	provider.mu.Lock()
+	provider.fetchedAt = time.Now().Add(-minKeyRefresh)
+	provider.mu.Unlock()
+	if err := verify(); err != nil {
+		t.Fatalf("VerifyIDToken after the refresh interval: %v", err)
+	}
+	if got := idp.JWKSFetches(); got != 2 {
+		t.Fatalf("JWKS fetched %d times, want 2", got)
+	}
+}
@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"trip-planner/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Permission is a capability granted to a role
+type Permission string
+
+const (
+	ManageUsers      Permission = "users:manage"
+	ManageAnyTrip    Permission = "trips:manage_any"
+	ModerateComments Permission = "comments:moderate"
+	ReadAuditLog     Permission = "audit:read"
+	RunMaintenance   Permission = "maintenance:run"
+	ManageCurrencies Permission = "currencies:manage"
+)
+
+// Scope limits what an API key may do. Bearer tokens from an interactive login are unscoped.
+type Scope string
+
+const (
+	ScopeTripsRead     Scope = "trips:read"
+	ScopeTripsWrite    Scope = "trips:write"
+	ScopeCommentsWrite Scope = "comments:write"
+)
+
+// ValidScope reports whether scope is one API keys can be granted
+func ValidScope(scope string) bool {
+	switch Scope(scope) {
+	case ScopeTripsRead, ScopeTripsWrite, ScopeCommentsWrite:
+		return true
+	}
+	return false
+}
+
+// rolePermissions lists the extra capabilities each role has on top of managing its own data
+var rolePermissions = map[string][]Permission{
+	models.RoleUser:      {},
+	models.RoleModerator: {ModerateComments},
+	models.RoleAdmin:     {ManageUsers, ManageAnyTrip, ModerateComments, ReadAuditLog, RunMaintenance, ManageCurrencies},
+}
+
+// Can reports whether the role has been granted the permission
+func Can(role string, permission Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// TripRolesFor lists the member roles that may perform an action needing at least minRole
+func TripRolesFor(minRole string) []string {
+	switch minRole {
+	case models.TripRoleOwner:
+		return []string{models.TripRoleOwner}
+	case models.TripRoleEditor:
+		return []string{models.TripRoleOwner, models.TripRoleEditor}
+	}
+	return []string{models.TripRoleOwner, models.TripRoleEditor, models.TripRoleViewer}
+}
+
+// CanAccessTrip reports whether the caller holds at least minRole on the trip
+func CanAccessTrip(userID primitive.ObjectID, role string, trip *models.Trip, minRole string) bool {
+	if Can(role, ManageAnyTrip) {
+		return true
+	}
+	held := trip.MemberRole(userID)
+	for _, allowed := range TripRolesFor(minRole) {
+		if held == allowed {
+			return true
+		}
+	}
+	return false
+}
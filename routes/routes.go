@@ -1,7 +1,10 @@
 package routes
 
 import (
+	"net/http"
 	"trip-planner/controllers"
+	"trip-planner/middleware"
+	"trip-planner/policy"
 
 	"github.com/gorilla/mux"
 )
@@ -10,23 +13,168 @@ import (
 func InitializeRoutes() *mux.Router {
 	r := mux.NewRouter()
 
+	// Public routes, no token required
+	public := r.NewRoute().Subrouter()
+
+	// Protected routes, the caller is authenticated once by the middleware
+	protected := r.NewRoute().Subrouter()
+	protected.Use(middleware.Authenticate)
+
+	// Routes that also accept personal API keys; every one of them names the scope it needs
+	automated := r.NewRoute().Subrouter()
+	automated.Use(middleware.AuthenticateWithAPIKeys)
+
+	// Routes that serve anonymous callers too, but check credentials when they're sent
+	optional := r.NewRoute().Subrouter()
+	optional.Use(middleware.AuthenticateOptional)
+
 	// User routes
-	r.HandleFunc("/register", controllers.RegisterUser).Methods("POST")
-	r.HandleFunc("/login", controllers.LoginUser).Methods("POST")
+	public.HandleFunc("/register", controllers.RegisterUser).Methods("POST")
+	public.HandleFunc("/login", controllers.LoginUser).Methods("POST")
+	public.HandleFunc("/login/mfa", controllers.LoginMFA).Methods("POST")
+	public.HandleFunc("/auth/oidc/login", controllers.OIDCLogin).Methods("GET")
+	public.HandleFunc("/auth/oidc/callback", controllers.OIDCCallback).Methods("GET")
+	public.HandleFunc("/token/refresh", controllers.RefreshToken).Methods("POST")
+	public.HandleFunc("/password/forgot", controllers.RequestPasswordReset).Methods("POST")
+	public.HandleFunc("/password/reset", controllers.ResetPassword).Methods("POST")
+	public.HandleFunc("/email/verify", controllers.VerifyEmail).Methods("POST")
+	public.HandleFunc("/email/verify/resend", controllers.ResendVerification).Methods("POST")
+	protected.HandleFunc("/logout", controllers.Logout).Methods("POST")
+	protected.HandleFunc("/logout-all", controllers.LogoutAll).Methods("POST")
+
+	// Profile routes
+	protected.HandleFunc("/me", controllers.GetMe).Methods("GET")                    // Get own profile
+	protected.HandleFunc("/me", controllers.UpdateMe).Methods("PATCH")               // Edit own profile
+	protected.HandleFunc("/me", controllers.DeleteMe).Methods("DELETE")              // Delete own account
+	protected.HandleFunc("/me/password", controllers.ChangePassword).Methods("POST") // Change password
+
+	// Session routes
+	protected.HandleFunc("/me/sessions", controllers.ListSessions).Methods("GET")             // List active sessions
+	protected.HandleFunc("/me/sessions/{id}", controllers.TerminateSession).Methods("DELETE") // Sign a session out
+
+	// Calendar routes
+	protected.HandleFunc("/me/calendar/conflicts", controllers.GetCalendarConflicts).Methods("GET") // Overlapping trips
+
+	// Two-factor authentication routes
+	protected.HandleFunc("/me/mfa/enroll", controllers.EnrollMFA).Methods("POST")                       // Generate a TOTP secret
+	protected.HandleFunc("/me/mfa/confirm", controllers.ConfirmMFA).Methods("POST")                     // Enable with a first code
+	protected.HandleFunc("/me/mfa/disable", controllers.DisableMFA).Methods("POST")                     // Turn 2FA off
+	protected.HandleFunc("/me/mfa/recovery-codes", controllers.RegenerateRecoveryCodes).Methods("POST") // Replace recovery codes
+
+	// API key routes
+	protected.HandleFunc("/me/api-keys", controllers.CreateAPIKey).Methods("POST")        // Create a personal API key
+	protected.HandleFunc("/me/api-keys", controllers.ListAPIKeys).Methods("GET")          // List API keys
+	protected.HandleFunc("/me/api-keys/{id}", controllers.RevokeAPIKey).Methods("DELETE") // Revoke an API key
 
 	// Trip routes
-	r.HandleFunc("/trips", controllers.CreateTrip).Methods("POST")                      // Create a new trip
-	r.HandleFunc("/trips/{id}", controllers.GetTripByID).Methods("GET")                 // Get trip by ID
-	r.HandleFunc("/trips", controllers.GetTrips).Methods("GET")                         // Get all trips
-	r.HandleFunc("/trips/{id}", controllers.UpdateTrip).Methods("PUT")                  // Update an existing trip
-	r.HandleFunc("/trips/{id}", controllers.DeleteTrip).Methods("DELETE")               // Delete a trip
+	automated.Handle("/trips", scoped(policy.ScopeTripsWrite, controllers.CreateTrip)).Methods("POST")        // Create a new trip
+	automated.Handle("/trips/{id}", scoped(policy.ScopeTripsRead, controllers.GetTripByID)).Methods("GET")    // Get trip by ID
+	automated.Handle("/trips", scoped(policy.ScopeTripsRead, controllers.GetTrips)).Methods("GET")            // Get all trips
+	automated.Handle("/trips/{id}", scoped(policy.ScopeTripsWrite, controllers.UpdateTrip)).Methods("PUT")    // Update an existing trip
+	automated.Handle("/trips/{id}", scoped(policy.ScopeTripsWrite, controllers.DeleteTrip)).Methods("DELETE") // Delete a trip
+
+	// Public trip discovery
+	public.HandleFunc("/explore/trips", controllers.ExploreTrips).Methods("GET")        // List public trips
+	public.HandleFunc("/explore/trips/{id}", controllers.GetExploreTrip).Methods("GET") // Read a public or unlisted trip
+
+	// Itinerary routes
+	automated.Handle("/trips/{id}/days", scoped(policy.ScopeTripsRead, controllers.ListTripDays)).Methods("GET")               // Get the itinerary
+	automated.Handle("/trips/{id}/days", scoped(policy.ScopeTripsWrite, controllers.CreateTripDay)).Methods("POST")            // Add a day
+	automated.Handle("/trips/{id}/days/reorder", scoped(policy.ScopeTripsWrite, controllers.ReorderTripDays)).Methods("POST")  // Reorder days
+	automated.Handle("/trips/{id}/days/{day_id}", scoped(policy.ScopeTripsWrite, controllers.UpdateTripDay)).Methods("PUT")    // Edit a day
+	automated.Handle("/trips/{id}/days/{day_id}", scoped(policy.ScopeTripsWrite, controllers.DeleteTripDay)).Methods("DELETE") // Remove a day
+	automated.Handle("/trips/{id}/stops", scoped(policy.ScopeTripsRead, controllers.ListStops)).Methods("GET")                 // List stops
+	automated.Handle("/trips/{id}/stops", scoped(policy.ScopeTripsWrite, controllers.CreateStop)).Methods("POST")              // Add a stop
+	automated.Handle("/trips/{id}/stops/reorder", scoped(policy.ScopeTripsWrite, controllers.ReorderStops)).Methods("POST")    // Reorder stops within a day
+	automated.Handle("/trips/{id}/stops/{stop_id}", scoped(policy.ScopeTripsRead, controllers.GetStop)).Methods("GET")         // Get a stop
+	automated.Handle("/trips/{id}/stops/{stop_id}", scoped(policy.ScopeTripsWrite, controllers.UpdateStop)).Methods("PUT")     // Edit or move a stop
+	automated.Handle("/trips/{id}/stops/{stop_id}", scoped(policy.ScopeTripsWrite, controllers.DeleteStop)).Methods("DELETE")  // Remove a stop
+
+	// Expense and budget routes
+	automated.Handle("/trips/{id}/expenses", scoped(policy.ScopeTripsRead, controllers.ListExpenses)).Methods("GET")                   // List expenses
+	automated.Handle("/trips/{id}/expenses", scoped(policy.ScopeTripsWrite, controllers.CreateExpense)).Methods("POST")                // Record an expense
+	automated.Handle("/trips/{id}/expenses/{expense_id}", scoped(policy.ScopeTripsRead, controllers.GetExpense)).Methods("GET")        // Get an expense
+	automated.Handle("/trips/{id}/expenses/{expense_id}", scoped(policy.ScopeTripsWrite, controllers.UpdateExpense)).Methods("PUT")    // Edit an expense
+	automated.Handle("/trips/{id}/expenses/{expense_id}", scoped(policy.ScopeTripsWrite, controllers.DeleteExpense)).Methods("DELETE") // Remove an expense
+	automated.Handle("/trips/{id}/budget", scoped(policy.ScopeTripsRead, controllers.GetTripBudget)).Methods("GET")                    // Spending against the budget
+	automated.Handle("/trips/{id}/budget", scoped(policy.ScopeTripsWrite, controllers.SetTripBudget)).Methods("PUT")                   // Set the budget
+	automated.Handle("/trips/{id}/settle-up", scoped(policy.ScopeTripsRead, controllers.SettleTrip)).Methods("GET")                    // Who owes whom
+
+	// Currency routes
+	protected.HandleFunc("/currencies", controllers.ListCurrencies).Methods("GET") // Supported currencies
+
+	// Trip member routes
+	automated.Handle("/trips/{id}/members", scoped(policy.ScopeTripsRead, controllers.ListTripMembers)).Methods("GET")                // List members
+	automated.Handle("/trips/{id}/members", scoped(policy.ScopeTripsWrite, controllers.AddTripMember)).Methods("POST")                // Add a member
+	automated.Handle("/trips/{id}/members/{user_id}", scoped(policy.ScopeTripsWrite, controllers.UpdateTripMember)).Methods("PUT")    // Change a member's role
+	automated.Handle("/trips/{id}/members/{user_id}", scoped(policy.ScopeTripsWrite, controllers.RemoveTripMember)).Methods("DELETE") // Remove a member or leave
+
+	// Trip invite routes
+	automated.Handle("/trips/{id}/invites", scoped(policy.ScopeTripsWrite, controllers.CreateTripInvite)).Methods("POST")               // Create an invite link
+	automated.Handle("/trips/{id}/invites", scoped(policy.ScopeTripsRead, controllers.ListTripInvites)).Methods("GET")                  // List open invites
+	automated.Handle("/trips/{id}/invites/{invite_id}", scoped(policy.ScopeTripsWrite, controllers.RevokeTripInvite)).Methods("DELETE") // Revoke an invite
+	protected.HandleFunc("/invites/accept", controllers.AcceptTripInvite).Methods("POST")                                               // Join a trip from an invite
+
+	// Share link routes
+	automated.Handle("/trips/{id}/shares", scoped(policy.ScopeTripsWrite, controllers.CreateShareLink)).Methods("POST")              // Create a share link
+	automated.Handle("/trips/{id}/shares", scoped(policy.ScopeTripsRead, controllers.ListShareLinks)).Methods("GET")                 // List active share links
+	automated.Handle("/trips/{id}/shares/{share_id}", scoped(policy.ScopeTripsWrite, controllers.RevokeShareLink)).Methods("DELETE") // Revoke a share link
+	public.HandleFunc("/shared/trip", controllers.GetSharedTrip).Methods("GET")                                                      // Read a shared trip
 
 	// Comment routes
-	r.HandleFunc("/comments/{trip_id}/comments", controllers.CreateComment).Methods("POST")   // Create comment
-	r.HandleFunc("/comments/{trip_id}/comments", controllers.GetComments).Methods("GET")    // Get all comments for a specific trip
-	r.HandleFunc("/comments/{trip_id}/comments/{id}", controllers.GetCommentByID).Methods("GET") // Get comment by ID
-	r.HandleFunc("/comments/{trip_id}/comments/{id}", controllers.UpdateComment).Methods("PUT")   // Update comment
-	r.HandleFunc("/comments/{trip_id}/comments/{id}", controllers.DeleteComment).Methods("DELETE") // Delete comment
+	automated.Handle("/comments/{trip_id}/comments", scoped(policy.ScopeCommentsWrite, controllers.CreateComment)).Methods("POST")         // Create comment
+	optional.Handle("/comments/{trip_id}/comments", optionalScoped(policy.ScopeTripsRead, controllers.GetComments)).Methods("GET")         // Get all comments for a specific trip
+	optional.Handle("/comments/{trip_id}/comments/{id}", optionalScoped(policy.ScopeTripsRead, controllers.GetCommentByID)).Methods("GET") // Get comment by ID
+	automated.Handle("/comments/{trip_id}/comments/{id}", scoped(policy.ScopeCommentsWrite, controllers.UpdateComment)).Methods("PUT")     // Update comment
+	automated.Handle("/comments/{trip_id}/comments/{id}", scoped(policy.ScopeCommentsWrite, controllers.DeleteComment)).Methods("DELETE")  // Delete comment
+
+	// Admin routes, each group requires the matching permission
+	adminUsers := protected.PathPrefix("/admin/users").Subrouter()
+	adminUsers.Use(middleware.RequirePermission(policy.ManageUsers))
+	adminUsers.HandleFunc("", controllers.ListUsers).Methods("GET")                 // List users
+	adminUsers.HandleFunc("/{id}/role", controllers.UpdateUserRole).Methods("PUT")  // Change a user's role
+	adminUsers.HandleFunc("/{id}/status", controllers.SetUserStatus).Methods("PUT") // Disable or enable an account
+
+	adminServiceAccounts := protected.PathPrefix("/admin/service-accounts").Subrouter()
+	adminServiceAccounts.Use(middleware.RequirePermission(policy.ManageUsers))
+	adminServiceAccounts.HandleFunc("", controllers.CreateServiceAccount).Methods("POST")                     // Create a service account
+	adminServiceAccounts.HandleFunc("/{id}/api-keys", controllers.CreateServiceAccountAPIKey).Methods("POST") // Mint a key for it
+
+	adminTrips := protected.PathPrefix("/admin/trips").Subrouter()
+	adminTrips.Use(middleware.RequirePermission(policy.ManageAnyTrip))
+	adminTrips.HandleFunc("/{id}", controllers.AdminDeleteTrip).Methods("DELETE") // Delete any trip
+
+	adminComments := protected.PathPrefix("/admin/comments").Subrouter()
+	adminComments.Use(middleware.RequirePermission(policy.ModerateComments))
+	adminComments.HandleFunc("/{id}", controllers.AdminDeleteComment).Methods("DELETE") // Delete any comment
+
+	adminAudit := protected.PathPrefix("/admin/audit").Subrouter()
+	adminAudit.Use(middleware.RequirePermission(policy.ReadAuditLog))
+	adminAudit.HandleFunc("", controllers.ListAuditEntries).Methods("GET")          // Query the audit log
+	adminAudit.HandleFunc("/export", controllers.ExportAuditEntries).Methods("GET") // Export it as NDJSON
+
+	adminMaintenance := protected.PathPrefix("/admin/maintenance").Subrouter()
+	adminMaintenance.Use(middleware.RequirePermission(policy.RunMaintenance))
+	adminMaintenance.HandleFunc("/orphans", controllers.ScanOrphans).Methods("GET")                        // Report orphaned comments
+	adminMaintenance.HandleFunc("/orphans/clean", controllers.CleanOrphans).Methods("POST")                // Clean them up
+	adminMaintenance.HandleFunc("/migrations/attractions", controllers.MigrateAttractions).Methods("POST") // Turn attractions text into stops
+
+	adminCurrencies := protected.PathPrefix("/admin/currencies").Subrouter()
+	adminCurrencies.Use(middleware.RequirePermission(policy.ManageCurrencies))
+	adminCurrencies.HandleFunc("/rates", controllers.ListExchangeRates).Methods("GET")           // List exchange rates
+	adminCurrencies.HandleFunc("/rates/import", controllers.ImportExchangeRates).Methods("POST") // Import rates from CSV or JSON
+	adminCurrencies.HandleFunc("/rates/{id}", controllers.DeleteExchangeRate).Methods("DELETE")  // Remove a rate
 
 	return r
 }
+
+// scoped wraps a handler so API key callers need the given scope
+func scoped(scope policy.Scope, handler http.HandlerFunc) http.Handler {
+	return middleware.RequireScope(scope)(handler)
+}
+
+// optionalScoped wraps a handler on a route that also serves anonymous callers, so API key
+// callers need the given scope
+func optionalScoped(scope policy.Scope, handler http.HandlerFunc) http.Handler {
+	return middleware.OptionalScope(scope)(handler)
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"trip-planner/db"
+	"trip-planner/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// apiKeyPrefix marks our keys so secret scanners and humans can recognise them
+const apiKeyPrefix = "tp_"
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+// GenerateAPIKey returns a new key as "tp_<prefix>_<secret>" together with its public prefix
+func GenerateAPIKey() (key, prefix string, err error) {
+	prefix, err = RandomToken(6)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := RandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	// The prefix must not contain the separator
+	prefix = strings.ReplaceAll(prefix, "_", "-")
+	return apiKeyPrefix + prefix + "_" + secret, prefix, nil
+}
+
+// AuthenticateAPIKey looks up a key and its owner, rejecting revoked or expired keys and
+// disabled owners. The key's last use is recorded.
+func AuthenticateAPIKey(ctx context.Context, key string) (*models.APIKey, *models.User, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	var apiKey models.APIKey
+	err := db.APIKeyCollection.FindOne(ctx, bson.M{"key_hash": HashToken(key)}).Decode(&apiKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt)) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	var user models.User
+	err = db.UserCollection.FindOne(ctx, bson.M{"_id": apiKey.UserID}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+	if user.Disabled {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	if _, err := db.APIKeyCollection.UpdateOne(ctx, bson.M{"_id": apiKey.ID}, bson.M{"$set": bson.M{"last_used_at": now}}); err != nil {
+		return nil, nil, err
+	}
+	return &apiKey, &user, nil
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"time"
+	"trip-planner/db"
+	"trip-planner/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RecordAudit appends an entry to the audit collection. A failed write is logged
+// rather than failing the request that triggered it.
+func RecordAudit(ctx context.Context, entry models.AuditEntry) {
+	entry.ID = primitive.NewObjectID()
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	if entry.Outcome == "" {
+		entry.Outcome = models.AuditSuccess
+	}
+	if _, err := db.AuditCollection.InsertOne(ctx, entry); err != nil {
+		log.Printf("Failed to record audit entry %s on %s %s: %v", entry.Action, entry.TargetType, entry.TargetID.Hex(), err)
+	}
+}
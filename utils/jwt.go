@@ -2,45 +2,169 @@ package utils
 
 import (
 	"errors"
+	"os"
 	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v4"
 )
 
-var secretKey = []byte("your_secret_key")
+// Token lifetimes, overridable with JWT_ACCESS_TTL and JWT_REFRESH_TTL
+var (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// MFATokenTTL is how long a user has to enter their second factor after the password step
+const MFATokenTTL = 5 * time.Minute
+
+// Purposes of restricted tokens that never grant API access
+const (
+	PurposeMFA        = "mfa"         // only proves the password step of a two-factor login
+	PurposeTripInvite = "trip_invite" // lets a user join a trip, the subject is the invite ID
+	PurposeTripShare  = "trip_share"  // lets anyone read a trip, the subject is the share link ID
+)
 
 // Claims represents the payload of the JWT token
 type Claims struct {
-	UserID string `json:"user_id"`
-	jwt.StandardClaims
+	UserID    string `json:"user_id"`
+	Role      string `json:"role,omitempty"`
+	SessionID string `json:"sid,omitempty"`     // refresh token family the access token belongs to
+	Purpose   string `json:"purpose,omitempty"` // empty for access tokens, set for restricted tokens
+	jwt.RegisteredClaims
+}
+
+// loadTTLs reads the token lifetimes from the environment
+func loadTTLs() error {
+	if value := os.Getenv("JWT_ACCESS_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return errors.New("invalid JWT_ACCESS_TTL")
+		}
+		AccessTokenTTL = ttl
+	}
+	if value := os.Getenv("JWT_REFRESH_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return errors.New("invalid JWT_REFRESH_TTL")
+		}
+		RefreshTokenTTL = ttl
+	}
+	return nil
+}
+
+// GenerateJWT generates a short-lived access token for a user within a token family
+func GenerateJWT(userID, role, sessionID string) (string, error) {
+	return signToken(Claims{UserID: userID, Role: role, SessionID: sessionID}, AccessTokenTTL)
+}
+
+// GenerateMFAToken issues the "mfa pending" token returned after a correct password when the
+// account has two-factor authentication. It can only be exchanged at the MFA login step.
+func GenerateMFAToken(userID string) (string, error) {
+	return signToken(Claims{UserID: userID, Purpose: PurposeMFA}, MFATokenTTL)
+}
+
+// GenerateInviteToken signs an invite link token for the invite. It expires with the invite.
+func GenerateInviteToken(inviteID string, ttl time.Duration) (string, error) {
+	return signToken(Claims{Purpose: PurposeTripInvite, RegisteredClaims: jwt.RegisteredClaims{Subject: inviteID}}, ttl)
+}
+
+// GenerateShareToken signs a share link token for the link. A ttl of 0 gives a token that
+// doesn't expire on its own; such links stay valid until revoked.
+func GenerateShareToken(shareID string, ttl time.Duration) (string, error) {
+	return signToken(Claims{Purpose: PurposeTripShare, RegisteredClaims: jwt.RegisteredClaims{Subject: shareID}}, ttl)
 }
 
-// GenerateJWT generates a JWT with a user ID
-func GenerateJWT(userID string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := Claims{
-		UserID: userID,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-			Issuer:    "trip-planner",
-		},
+// signToken fills in the registered claims and signs with the active key. A ttl of 0 leaves
+// out the expiry, which only restricted tokens backed by a revocable record may use.
+func signToken(claims Claims, ttl time.Duration) (string, error) {
+	if keys == nil {
+		return "", errors.New("JWT keys not loaded")
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secretKey)
+	jti, err := RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:       jti,
+		IssuedAt: jwt.NewNumericDate(now),
+		Issuer:   "trip-planner",
+		Subject:  claims.Subject,
+	}
+	if ttl > 0 {
+		claims.ExpiresAt = jwt.NewNumericDate(now.Add(ttl))
+	}
+
+	token := jwt.NewWithClaims(keys.active.Method, claims)
+	token.Header["kid"] = keys.active.ID
+	return token.SignedString(keys.active.Sign)
 }
 
-// ValidateJWT validates the token and extracts claims
+// ValidateJWT validates the token, checks it against the revocation list and extracts claims
 func ValidateJWT(tokenString string) (*Claims, error) {
 	if !strings.HasPrefix(tokenString, "Bearer ") {
 		return nil, errors.New("invalid token format")
 	}
-	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(t *jwt.Token) (interface{}, error) {
-		return secretKey, nil
-	})
+	claims, err := parseToken(strings.TrimPrefix(tokenString, "Bearer "))
+	if err != nil {
+		return nil, err
+	}
+
+	// Restricted tokens such as "mfa pending" never grant API access, and access tokens always expire
+	if claims.Purpose != "" || claims.ExpiresAt == nil {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
+// ValidateMFAToken validates an "mfa pending" token and extracts its claims
+func ValidateMFAToken(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != PurposeMFA {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// ValidateInviteToken validates a trip invite token and extracts its claims
+func ValidateInviteToken(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != PurposeTripInvite || claims.Subject == "" {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// ValidateShareToken validates a trip share token and extracts its claims
+func ValidateShareToken(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != PurposeTripShare || claims.Subject == "" {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// parseToken verifies the signature and expiry and checks the revocation list
+func parseToken(tokenString string) (*Claims, error) {
+	if keys == nil {
+		return nil, errors.New("JWT keys not loaded")
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keys.verificationKey)
 	if err != nil {
 		return nil, err
 	}
@@ -50,5 +174,13 @@ func ValidateJWT(tokenString string) (*Claims, error) {
 		return nil, errors.New("invalid token")
 	}
 
+	revoked, err := IsTokenRevoked(claims)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("token has been revoked")
+	}
+
 	return claims, nil
 }
@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// signingKey is a key used to sign or verify tokens, identified by its kid
+type signingKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Sign   interface{} // nil for verification-only keys
+	Verify interface{}
+}
+
+// keyring holds the active signing key and every key still accepted for verification
+type keyring struct {
+	active *signingKey
+	byID   map[string]*signingKey
+}
+
+var keys *keyring
+
+// LoadJWTConfig builds the keyring from the environment. It must be called before
+// GenerateJWT or ValidateJWT.
+//
+//	JWT_ALGORITHM          HS256 (default), RS256 or EdDSA
+//	JWT_KEY_ID             kid placed in the token header (default "default")
+//	JWT_SECRET_KEY         HMAC secret for HS256
+//	JWT_SECRET_FILE        file holding the HMAC secret, used when JWT_SECRET_KEY is empty
+//	JWT_PRIVATE_KEY_FILE   PEM private key for RS256 or EdDSA
+//	JWT_VERIFICATION_KEYS  extra keys accepted during rotation, as "kid=path,kid=path";
+//	                       each file holds a PEM public key or a raw HMAC secret
+//	JWT_ACCESS_TTL         access token lifetime (default 15m)
+//	JWT_REFRESH_TTL        refresh token lifetime (default 720h)
+func LoadJWTConfig() error {
+	if err := loadTTLs(); err != nil {
+		return err
+	}
+	kr, err := loadKeyring()
+	if err != nil {
+		return err
+	}
+	keys = kr
+	return nil
+}
+
+func loadKeyring() (*keyring, error) {
+	kid := os.Getenv("JWT_KEY_ID")
+	if kid == "" {
+		kid = "default"
+	}
+
+	algorithm := strings.ToUpper(os.Getenv("JWT_ALGORITHM"))
+	if algorithm == "" {
+		algorithm = "HS256"
+	}
+
+	var active *signingKey
+	switch algorithm {
+	case "HS256":
+		secret, err := loadSecret()
+		if err != nil {
+			return nil, err
+		}
+		active = &signingKey{ID: kid, Method: jwt.SigningMethodHS256, Sign: secret, Verify: secret}
+	case "RS256", "EDDSA":
+		path := os.Getenv("JWT_PRIVATE_KEY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE is required for %s", algorithm)
+		}
+		key, err := loadPrivateKey(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		// The key type decides how tokens are signed, so it must be the one asked for
+		if !strings.EqualFold(key.Method.Alg(), algorithm) {
+			return nil, fmt.Errorf("JWT_ALGORITHM is %s but %s holds a key for %s", algorithm, path, key.Method.Alg())
+		}
+		active = key
+	default:
+		return nil, fmt.Errorf("unsupported JWT_ALGORITHM %q", algorithm)
+	}
+
+	kr := &keyring{active: active, byID: map[string]*signingKey{active.ID: active}}
+
+	for _, entry := range strings.Split(os.Getenv("JWT_VERIFICATION_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, path, ok := strings.Cut(entry, "=")
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("invalid JWT_VERIFICATION_KEYS entry %q", entry)
+		}
+		if _, exists := kr.byID[id]; exists {
+			return nil, fmt.Errorf("duplicate JWT key id %q", id)
+		}
+		key, err := loadVerificationKey(id, path)
+		if err != nil {
+			return nil, err
+		}
+		kr.byID[id] = key
+	}
+
+	return kr, nil
+}
+
+// loadSecret reads the HMAC secret from JWT_SECRET_KEY or JWT_SECRET_FILE
+func loadSecret() ([]byte, error) {
+	if secret := os.Getenv("JWT_SECRET_KEY"); secret != "" {
+		return []byte(secret), nil
+	}
+	if path := os.Getenv("JWT_SECRET_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading JWT secret file: %w", err)
+		}
+		secret := strings.TrimSpace(string(data))
+		if secret == "" {
+			return nil, errors.New("JWT secret file is empty")
+		}
+		return []byte(secret), nil
+	}
+	return nil, errors.New("JWT_SECRET_KEY or JWT_SECRET_FILE must be set")
+}
+
+// loadPrivateKey reads a PEM encoded RSA or Ed25519 private key
+func loadPrivateKey(kid, path string) (*signingKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key %s: %w", path, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{ID: kid, Method: jwt.SigningMethodRS256, Sign: key, Verify: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{ID: kid, Method: jwt.SigningMethodEdDSA, Sign: key, Verify: key.Public()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type in %s", path)
+	}
+}
+
+// loadVerificationKey reads a PEM public key, or treats the file as a raw HMAC secret
+func loadVerificationKey(kid, path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWT key %s: %w", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		secret := strings.TrimSpace(string(data))
+		if secret == "" {
+			return nil, fmt.Errorf("JWT key file %s is empty", path)
+		}
+		return &signingKey{ID: kid, Method: jwt.SigningMethodHS256, Verify: []byte(secret)}, nil
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key %s: %w", path, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PublicKey:
+		return &signingKey{ID: kid, Method: jwt.SigningMethodRS256, Verify: key}, nil
+	case ed25519.PublicKey:
+		return &signingKey{ID: kid, Method: jwt.SigningMethodEdDSA, Verify: key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type in %s", path)
+	}
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWT key %s: %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found in %s", path)
+	}
+	return block, nil
+}
+
+// verificationKey picks the key for an incoming token by kid, rejecting algorithm mismatches
+func (kr *keyring) verificationKey(t *jwt.Token) (interface{}, error) {
+	key := kr.active
+	if kid, ok := t.Header["kid"].(string); ok && kid != "" {
+		key, ok = kr.byID[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+	}
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+	}
+	return key.Verify, nil
+}
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePrivateKey writes the key as a PKCS #8 PEM file and returns its path
+func writePrivateKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadKeyringChecksKeyType(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPath, edPath := writePrivateKey(t, rsaKey), writePrivateKey(t, edKey)
+
+	tests := []struct {
+		name      string
+		algorithm string
+		path      string
+		wantAlg   string // empty when loading must fail
+	}{
+		{name: "RSA key for RS256", algorithm: "RS256", path: rsaPath, wantAlg: "RS256"},
+		{name: "Ed25519 key for EdDSA", algorithm: "EdDSA", path: edPath, wantAlg: "EdDSA"},
+		{name: "lower case algorithm", algorithm: "eddsa", path: edPath, wantAlg: "EdDSA"},
+		{name: "Ed25519 key for RS256", algorithm: "RS256", path: edPath},
+		{name: "RSA key for EdDSA", algorithm: "EdDSA", path: rsaPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_ALGORITHM", tt.algorithm)
+			t.Setenv("JWT_PRIVATE_KEY_FILE", tt.path)
+			t.Setenv("JWT_VERIFICATION_KEYS", "")
+
+			kr, err := loadKeyring()
+			if tt.wantAlg == "" {
+				if err == nil || !strings.Contains(err.Error(), "JWT_ALGORITHM") {
+					t.Fatalf("loadKeyring error = %v, want a key type mismatch", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadKeyring: %v", err)
+			}
+			if got := kr.active.Method.Alg(); got != tt.wantAlg {
+				t.Errorf("signing method = %s, want %s", got, tt.wantAlg)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"crypto/subtle"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptPrefixes lists the version markers a bcrypt hash may start with.
+// Anything else stored in the password field is treated as a legacy plaintext value.
+var bcryptPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+
+// passwordCost returns the bcrypt cost from BCRYPT_COST, falling back to bcrypt.DefaultCost
+func passwordCost() int {
+	cost, err := strconv.Atoi(os.Getenv("BCRYPT_COST"))
+	if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return bcrypt.DefaultCost
+	}
+	return cost
+}
+
+// isBcryptHash reports whether the stored value looks like a bcrypt hash
+func isBcryptHash(stored string) bool {
+	for _, prefix := range bcryptPrefixes {
+		if strings.HasPrefix(stored, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HashPassword hashes a plaintext password with bcrypt at the configured cost
+func HashPassword(password string) (string, error) {
+	if password == "" {
+		return "", errors.New("password cannot be empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost())
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// VerifyPassword checks a plaintext password against the stored value.
+// Legacy plaintext rows are compared in constant time so they keep working until rehashed.
+func VerifyPassword(stored, password string) bool {
+	if stored == "" || password == "" {
+		return false
+	}
+	if isBcryptHash(stored) {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// PasswordNeedsRehash reports whether the stored value is plaintext or was hashed with a weaker cost
+func PasswordNeedsRehash(stored string) bool {
+	if !isBcryptHash(stored) {
+		return true
+	}
+	cost, err := bcrypt.Cost([]byte(stored))
+	if err != nil {
+		return true
+	}
+	return cost < passwordCost()
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// SimulatePasswordCheck spends the same time as verifying a real hash. Login calls it when
+// no user matched so response timing doesn't reveal which accounts exist.
+func SimulatePasswordCheck(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), passwordCost())
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ClientIP returns the caller's IP address. X-Forwarded-For is only honoured when
+// TRUST_PROXY=true, since clients can set it to anything otherwise.
+func ClientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") == "true" {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ClientInfo describes the device a login came from
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// ClientInfoFromRequest extracts the user agent and IP of the caller
+func ClientInfoFromRequest(r *http.Request) ClientInfo {
+	userAgent := r.UserAgent()
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	return ClientInfo{UserAgent: userAgent, IP: ClientIP(r)}
+}
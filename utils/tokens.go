@@ -0,0 +1,257 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+	"trip-planner/db"
+	"trip-planner/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// TokenPair is what a successful login or refresh hands back to the client
+type TokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// RandomToken returns n random bytes encoded as URL-safe base64
+func RandomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashToken returns the SHA-256 hex digest under which opaque tokens are stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueTokenPair starts a new session (token family) for the user and returns its first
+// access and refresh tokens
+func IssueTokenPair(ctx context.Context, user *models.User, client ClientInfo) (*TokenPair, error) {
+	family, err := RandomToken(16)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ID:         family,
+		UserID:     user.ID,
+		UserAgent:  client.UserAgent,
+		IP:         client.IP,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(RefreshTokenTTL),
+	}
+	if _, err := db.SessionCollection.InsertOne(ctx, session); err != nil {
+		return nil, err
+	}
+
+	return issueInFamily(ctx, user, family)
+}
+
+// issueInFamily stores a new refresh token in the family and signs a matching access token
+func issueInFamily(ctx context.Context, user *models.User, family string) (*TokenPair, error) {
+	refresh, err := RandomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	record := models.RefreshToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		Family:    family,
+		TokenHash: HashToken(refresh),
+		CreatedAt: now,
+		ExpiresAt: now.Add(RefreshTokenTTL),
+	}
+	if _, err := db.RefreshTokenCollection.InsertOne(ctx, record); err != nil {
+		return nil, err
+	}
+
+	access, err := GenerateJWT(user.ID.Hex(), user.EffectiveRole(), family)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		Token:        access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new pair in the same family.
+// Presenting a token that was already used revokes the whole family.
+func RotateRefreshToken(ctx context.Context, refresh string, client ClientInfo) (*TokenPair, error) {
+	var record models.RefreshToken
+	err := db.RefreshTokenCollection.FindOne(ctx, bson.M{"token_hash": HashToken(refresh)}).Decode(&record)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if record.Revoked || time.Now().After(record.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// Claim the token atomically so two concurrent refreshes can't both succeed
+	now := time.Now()
+	result, err := db.RefreshTokenCollection.UpdateOne(ctx,
+		bson.M{"_id": record.ID, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": now}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		if err := RevokeFamily(ctx, record.UserID, record.Family, "refresh token reuse"); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	// Reload the user so role changes apply and disabled accounts can't keep refreshing
+	var user models.User
+	err = db.UserCollection.FindOne(ctx, bson.M{"_id": record.UserID}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if err == mongo.ErrNoDocuments || user.Disabled {
+		if err := RevokeFamily(ctx, record.UserID, record.Family, "account unavailable"); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// Keep the session's last-seen details current
+	_, err = db.SessionCollection.UpdateOne(ctx,
+		bson.M{"_id": record.Family},
+		bson.M{"$set": bson.M{
+			"last_seen_at": now,
+			"ip":           client.IP,
+			"user_agent":   client.UserAgent,
+			"expires_at":   now.Add(RefreshTokenTTL),
+		}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return issueInFamily(ctx, &user, record.Family)
+}
+
+// RevokeAccessToken adds a single access token to the revocation list until it expires
+func RevokeAccessToken(ctx context.Context, claims *Claims, reason string) error {
+	userID, err := primitive.ObjectIDFromHex(claims.UserID)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(AccessTokenTTL)
+	if claims.ExpiresAt != nil {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	return addRevocation(ctx, models.RevokedToken{
+		ID:        "jti:" + claims.ID,
+		UserID:    userID,
+		Reason:    reason,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// RevokeFamily terminates a session: every refresh token in the family and every access
+// token issued from it stop working
+func RevokeFamily(ctx context.Context, userID primitive.ObjectID, family, reason string) error {
+	_, err := db.RefreshTokenCollection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "family": family},
+		bson.M{"$set": bson.M{"revoked": true}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = db.SessionCollection.UpdateOne(ctx,
+		bson.M{"_id": family, "user_id": userID, "terminated_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"terminated_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	return addRevocation(ctx, models.RevokedToken{
+		ID:        "family:" + family,
+		UserID:    userID,
+		Reason:    reason,
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	})
+}
+
+// RevokeAllForUser revokes every live token family belonging to the user
+func RevokeAllForUser(ctx context.Context, userID primitive.ObjectID, reason string) error {
+	return RevokeOtherFamilies(ctx, userID, "", reason)
+}
+
+// RevokeOtherFamilies revokes every live token family of the user except keep
+func RevokeOtherFamilies(ctx context.Context, userID primitive.ObjectID, keep, reason string) error {
+	families, err := db.RefreshTokenCollection.Distinct(ctx, "family", bson.M{"user_id": userID, "revoked": false})
+	if err != nil {
+		return err
+	}
+	for _, family := range families {
+		name, ok := family.(string)
+		if !ok || name == keep {
+			continue
+		}
+		if err := RevokeFamily(ctx, userID, name, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsTokenRevoked reports whether the token's jti or its family is on the revocation list
+func IsTokenRevoked(claims *Claims) (bool, error) {
+	if db.RevokedTokenCollection == nil {
+		return false, errors.New("token store not initialized")
+	}
+
+	ids := []string{"jti:" + claims.ID}
+	if claims.SessionID != "" {
+		ids = append(ids, "family:"+claims.SessionID)
+	}
+
+	count, err := db.RevokedTokenCollection.CountDocuments(context.Background(), bson.M{"_id": bson.M{"$in": ids}}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func addRevocation(ctx context.Context, entry models.RevokedToken) error {
+	_, err := db.RevokedTokenCollection.UpdateOne(ctx,
+		bson.M{"_id": entry.ID},
+		bson.M{"$set": bson.M{"user_id": entry.UserID, "reason": entry.Reason, "expires_at": entry.ExpiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
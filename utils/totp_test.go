@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed of the RFC 6238 appendix B test vectors, "12345678901234567890",
+// in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTPReferenceVectors(t *testing.T) {
+	// RFC 6238 appendix B gives 8 digit codes; 6 digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+	for _, tt := range tests {
+		now := time.Unix(tt.unix, 0)
+		step, ok := ValidateTOTP(rfc6238Secret, tt.code, now)
+		if !ok {
+			t.Errorf("ValidateTOTP(%s) at %d rejected the reference code", tt.code, tt.unix)
+			continue
+		}
+		if want := tt.unix / totpPeriod; step != want {
+			t.Errorf("ValidateTOTP(%s) at %d matched step %d, want %d", tt.code, tt.unix, step, want)
+		}
+	}
+}
+
+func TestValidateTOTPWindow(t *testing.T) {
+	// 287082 is the code for step 1, which covers 30s to 59s
+	tests := []struct {
+		name   string
+		secret string // defaults to rfc6238Secret
+		unix   int64
+		code   string
+		wantOK bool
+	}{
+		{name: "current step", unix: 45, code: "287082", wantOK: true},
+		{name: "one step late", unix: 75, code: "287082", wantOK: true},
+		{name: "one step early", unix: 15, code: "287082", wantOK: true},
+		{name: "two steps late", unix: 95, code: "287082", wantOK: false},
+		{name: "surrounding spaces", unix: 45, code: " 287082 ", wantOK: true},
+		{name: "lowercase secret", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", unix: 45, code: "287082", wantOK: true},
+		{name: "wrong code", unix: 45, code: "287083", wantOK: false},
+		{name: "eight digits", unix: 59, code: "94287082", wantOK: false},
+		{name: "too short", unix: 45, code: "28708", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := tt.secret
+			if secret == "" {
+				secret = rfc6238Secret
+			}
+			step, ok := ValidateTOTP(secret, tt.code, time.Unix(tt.unix, 0))
+			if ok != tt.wantOK {
+				t.Fatalf("ValidateTOTP = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && step != 1 {
+				t.Errorf("matched step %d, want 1", step)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPBadSecret(t *testing.T) {
+	if _, ok := ValidateTOTP("not base32!", "287082", time.Unix(59, 0)); ok {
+		t.Error("accepted a code for an undecodable secret")
+	}
+}
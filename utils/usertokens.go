@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"time"
+	"trip-planner/db"
+	"trip-planner/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+
+// CreateUserToken issues a single-use token for the purpose, invalidating any earlier
+// unused token the user had for the same purpose. The plaintext is returned once.
+func CreateUserToken(ctx context.Context, userID primitive.ObjectID, purpose string, ttl time.Duration) (string, error) {
+	token, err := RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = db.UserTokenCollection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "purpose": purpose, "used_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"used_at": now}},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	record := models.UserToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: HashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if _, err := db.UserTokenCollection.InsertOne(ctx, record); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ConsumeUserToken marks a token as used and returns the user it was issued to.
+// The update is atomic so a token can only ever be redeemed once.
+func ConsumeUserToken(ctx context.Context, token, purpose string) (primitive.ObjectID, error) {
+	now := time.Now()
+	var record models.UserToken
+	err := db.UserTokenCollection.FindOneAndUpdate(ctx,
+		bson.M{
+			"token_hash": HashToken(token),
+			"purpose":    purpose,
+			"used_at":    bson.M{"$exists": false},
+			"expires_at": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"used_at": now}},
+	).Decode(&record)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return primitive.NilObjectID, ErrInvalidUserToken
+		}
+		return primitive.NilObjectID, err
+	}
+	return record.UserID, nil
+}
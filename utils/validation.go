@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// MaxPasswordLength is bcrypt's input limit in bytes; longer passwords can't be hashed
+const MaxPasswordLength = 72
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{3,32}$`)
+
+// NormalizeEmail trims and lowercases an email address so lookups are consistent
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ValidateEmail checks that the value is a bare email address
+func ValidateEmail(email string) error {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return errors.New("email must be a valid email address")
+	}
+	return nil
+}
+
+// ValidateUsername checks length and allowed characters
+func ValidateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return errors.New("username must be 3-32 characters of letters, digits, '.', '_' or '-'")
+	}
+	return nil
+}
+
+// ValidatePassword enforces the password policy. The upper bound is MaxPasswordLength.
+func ValidatePassword(password string) error {
+	if len(password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes", MaxPasswordLength)
+	}
+
+	var hasLetter, hasDigit bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLetter(c):
+			hasLetter = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		}
+	}
+	if !hasLetter || !hasDigit {
+		return errors.New("password must contain at least one letter and one digit")
+	}
+	return nil
+}